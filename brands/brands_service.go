@@ -5,24 +5,51 @@ import (
 	"fmt"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
 	"github.com/jmcvetta/neoism"
+	"github.com/rcrowley/go-metrics"
+	"strings"
 )
 
-//service maintains info about runners and index managers
+var (
+	writtenBrands   = metrics.GetOrRegisterCounter("brands.writes.written", metrics.DefaultRegistry)
+	unchangedBrands = metrics.GetOrRegisterCounter("brands.writes.unchanged", metrics.DefaultRegistry)
+)
+
+// service maintains info about runners and index managers
 type service struct {
-	conn neoutils.NeoConnection
+	conn          neoutils.NeoConnection
+	batchSize     int
+	parentPolicy  ParentPolicy
+	transactionID string
+	// rollbackOf is the version of the brand's history a write is rolling back to, if it is
+	rollbackOf int
+	// events is where the changes made are published, if anywhere
+	events EventSink
 }
 
 // NewCypherBrandsService provides functions for create, update, delete operations on brands in Neo4j,
-// plus other utility functions needed for a service
-func NewCypherBrandsService(cypherRunner neoutils.NeoConnection) service {
-	return service{cypherRunner}
+// plus other utility functions needed for a service. batchSize bounds the number of statements sent
+// in a single CypherBatch when writing many brands at once. parentPolicy decides how a brand whose parent
+// hasn't been written is handled.
+func NewCypherBrandsService(cypherRunner neoutils.NeoConnection, batchSize int, parentPolicy ParentPolicy) service {
+	return service{conn: cypherRunner, batchSize: batchSize, parentPolicy: parentPolicy}
 }
 
-//Initialise the driver
+// Initialise the driver
 func (s service) Initialise() error {
 
 	err := s.conn.EnsureIndexes(map[string]string{
-		"Identifier": "value",
+		"Identifier":  "value",
+		"Brand":       "pendingParentUUID",
+		"BrandChange": "uuid",
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// one index per label at a time, so the search index needs a call of its own
+	err = s.conn.EnsureIndexes(map[string]string{
+		"Brand": "prefLabelLower",
 	})
 
 	if err != nil {
@@ -34,26 +61,40 @@ func (s service) Initialise() error {
 		"Concept":       "uuid",
 		"Brand":         "uuid",
 		"TMEIdentifier": "value",
-		"UPPIdentifier": "value"})
+		"UPPIdentifier": "value",
+		"BrandChange":   "key"})
 }
 
-func (s service) Read(uuid string) (interface{}, bool, error) {
-	results := []struct {
-		Brand
-	}{}
-	query := &neoism.CypherQuery{
-		Statement: `
-                        MATCH (n:Brand {uuid:{uuid}})
+// brandProjection returns the brand bound to n in the shape of a Brand, for every query that reads whole brands
+const brandProjection = `
                         OPTIONAL MATCH (n)-[:HAS_PARENT]->(p:Thing)
                         OPTIONAL MATCH (upp:UPPIdentifier)-[:IDENTIFIES]->(n)
 			OPTIONAL MATCH (tme:TMEIdentifier)-[:IDENTIFIES]->(n)
                         RETURN n.uuid AS uuid, n.prefLabel AS prefLabel,
-                                n.strapline AS strapline, p.uuid as parentUUID,
+                                n.strapline AS strapline, coalesce(p.uuid, n.pendingParentUUID) as parentUUID,
                                 n.descriptionXML AS descriptionXML,
                                 n.description AS description, n.imageUrl AS _imageUrl, n.aliases as aliases,
                                 {uuids:collect(distinct upp.value), TME:collect(distinct tme.value)} as alternativeIdentifiers,
-                                labels(n) as types
-                                `,
+                                labels(n) as types, n.contentHash AS version
+                                `
+
+// Read returns the brand for the uuid. If the brand was merged into another, the brand it was merged into is
+// returned instead.
+func (s service) Read(uuid string) (interface{}, bool, error) {
+	brand, _, found, err := s.ReadVersion(uuid)
+	return brand, found, err
+}
+
+// ReadVersion returns the brand for the uuid as Read does, along with its version. The version changes whenever
+// the brand is written with different content, and is empty for a brand not written since versions were added.
+func (s service) ReadVersion(uuid string) (Brand, string, bool, error) {
+	results := []struct {
+		Brand
+		Version string `json:"version"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `
+                        MATCH (n:Brand {uuid:{uuid}})` + brandProjection,
 		Parameters: map[string]interface{}{
 			"uuid": uuid,
 		},
@@ -61,23 +102,175 @@ func (s service) Read(uuid string) (interface{}, bool, error) {
 	}
 	err := s.conn.CypherBatch([]*neoism.CypherQuery{query})
 	if err != nil {
-		return Brand{}, false, err
+		return Brand{}, "", false, err
 	}
 	if len(results) == 0 {
-		return Brand{}, false, nil
+		return s.readRedirect(uuid)
 	}
-	return results[0].Brand, true, nil
+	return results[0].Brand, results[0].Version, true, nil
+}
+
+// ReadMany returns the brands for the uuids in a single query, following merges as Read does. Every uuid is
+// a key in the result, with nil for those that aren't brands.
+func (s service) ReadMany(uuids []string) (map[string]*Brand, error) {
+	results := []struct {
+		Brand
+		Requested string `json:"requested"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `
+			UNWIND {uuids} AS requested
+			MATCH (t:Thing {uuid:requested})
+			OPTIONAL MATCH (t)-[:REDIRECTS_TO]->(r:Brand)
+			WITH requested, coalesce(r, t) AS n
+			WHERE n:Brand` + brandProjection + `, requested AS requested`,
+		Parameters: neoism.Props{
+			"uuids": nonNil(uuids),
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+
+	brands := map[string]*Brand{}
+	for _, uuid := range uuids {
+		brands[uuid] = nil
+	}
+	for i := range results {
+		brands[results[i].Requested] = &results[i].Brand
+	}
+	return brands, nil
 }
 
 func (s service) Write(thing interface{}) error {
-	brand := thing.(Brand)
+	_, err := s.WriteBrand(thing.(Brand))
+	return err
+}
+
+// WriteBrand writes the brand unless the stored brand already has the same content, and reports which happened.
+// It returns a ValidationError for an invalid brand, an IdentifierConflictError if any of the brand's
+// alternative identifiers belong to another concept, a CycleError if the parent descends from the brand, and
+// a MissingParentError if the parent policy is strict and the parent isn't a brand. A MergedBrandError is
+// returned for a uuid that was merged into another brand.
+func (s service) WriteBrand(brand Brand) (WriteStatus, error) {
+	return s.WriteBrandIf(brand, Precondition{})
+}
+
+// WriteBrandIf writes the brand as WriteBrand does, but only if the stored brand meets the precondition when the
+// write happens. Otherwise it returns a PreconditionFailedError.
+func (s service) WriteBrandIf(brand Brand, precondition Precondition) (WriteStatus, error) {
+	if err := brand.Validate(); err != nil {
+		return "", err
+	}
+
+	stored, err := s.storedBrands([]string{brand.UUID})
+	if err != nil {
+		return "", err
+	}
+	previous, exists := stored[brand.UUID]
+	if !exists {
+		if err := s.checkMerged(brand.UUID); err != nil {
+			return "", err
+		}
+	}
+	version := previous.Version
+	if !precondition.allows(version, exists) {
+		return "", PreconditionFailedError{UUID: brand.UUID, Version: version, Exists: exists}
+	}
+	if exists && version == brand.contentHash() {
+		unchangedBrands.Inc(1)
+		return Unchanged, nil
+	}
+
+	if err := s.checkIdentifierConflicts(brand); err != nil {
+		return "", err
+	}
+	if err := s.checkParent(brand); err != nil {
+		return "", err
+	}
+
+	queries, change := s.writeQueries(brand, previous.brand(exists))
+	if !precondition.empty() {
+		queries = append([]*neoism.CypherQuery{guardVersionQuery(brand.UUID, version, exists)}, queries...)
+	}
+	if err := s.conn.CypherBatch(queries); err != nil {
+		// the checks explain a batch that lost a race or closed a cycle; when they fail themselves, Neo4j
+		// is likely unavailable, which the batch's own error says
+		if !precondition.empty() {
+			if preconditionErr, ok := s.checkPrecondition(brand.UUID, precondition).(PreconditionFailedError); ok {
+				return "", preconditionErr
+			}
+		}
+		if mergedErr, ok := s.checkMerged(brand.UUID).(MergedBrandError); ok {
+			return "", mergedErr
+		}
+		if cycleErr, ok := s.findCycle(brand).(CycleError); ok {
+			return "", cycleErr
+		}
+		if parentErr, ok := s.checkParent(brand).(MissingParentError); ok {
+			return "", parentErr
+		}
+		return "", err
+	}
+	writtenBrands.Inc(1)
+	s.publish(change)
+	return Written, nil
+}
+
+// storedBrand is a brand as it is stored, with its version
+type storedBrand struct {
+	Brand
+	Version string `json:"version"`
+}
+
+// brand returns the stored brand, or nil if it doesn't exist
+func (b storedBrand) brand(exists bool) *Brand {
+	if !exists {
+		return nil
+	}
+	return &b.Brand
+}
+
+// storedBrands returns each of the brands that exist, keyed by uuid. Unlike Read it doesn't follow merges.
+func (s service) storedBrands(uuids []string) (map[string]storedBrand, error) {
+	if len(uuids) == 0 {
+		return map[string]storedBrand{}, nil
+	}
+
+	results := []storedBrand{}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (n:Brand)
+			WHERE n.uuid IN {uuids}` + brandProjection,
+		Parameters: neoism.Props{
+			"uuids": uuids,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+
+	stored := make(map[string]storedBrand, len(results))
+	for _, result := range results {
+		stored[result.UUID] = result
+	}
+	return stored, nil
+}
+
+// writeQueries returns the statements that replace everything stored for the brand, and record the change
+// from the previously stored brand, which it also returns
+func (s service) writeQueries(brand Brand, previous *Brand) ([]*neoism.CypherQuery, BrandChange) {
 	brandProps := map[string]interface{}{
 		"uuid":           brand.UUID,
 		"prefLabel":      brand.PrefLabel,
+		"prefLabelLower": strings.ToLower(brand.PrefLabel),
 		"strapline":      brand.Strapline,
 		"descriptionXML": brand.DescriptionXML,
 		"description":    brand.Description,
 		"imageUrl":       brand.ImageURL,
+		"contentHash":    brand.contentHash(),
 	}
 
 	var aliases []string
@@ -88,9 +281,13 @@ func (s service) Write(thing interface{}) error {
 
 	if len(aliases) > 0 {
 		brandProps["aliases"] = aliases
+		aliasesLower := make([]string, len(aliases))
+		for i, alias := range aliases {
+			aliasesLower[i] = strings.ToLower(alias)
+		}
+		brandProps["aliasesLower"] = aliasesLower
 	}
 
-
 	deleteParentRelationship := &neoism.CypherQuery{
 		Statement: `
                         MATCH (:Thing {uuid:{uuid}})-[r:HAS_PARENT]->(:Thing)
@@ -100,12 +297,17 @@ func (s service) Write(thing interface{}) error {
 		},
 	}
 
+	// identifiers moved onto the brand by a merge are kept, unless the brand now gives them itself
 	deleteIdentifiers := &neoism.CypherQuery{
 		Statement: `
                         MATCH (t:Thing {uuid:{uuid}})<-[ir:IDENTIFIES]-(i:Identifier)
+			WHERE i.mergedFrom IS NULL
+				OR (i:TMEIdentifier AND i.value IN {tme}) OR (i:UPPIdentifier AND i.value IN {upp})
                         DELETE ir, i`,
 		Parameters: neoism.Props{
 			"uuid": brand.UUID,
+			"tme":  nonNil(brand.AlternativeIdentifiers.TME),
+			"upp":  nonNil(brand.AlternativeIdentifiers.UUIDS),
 		},
 	}
 
@@ -115,28 +317,20 @@ func (s service) Write(thing interface{}) error {
                         SET n:Brand
                         SET n:Concept
 			SET n:Classification
-                        SET n={props}`,
+                        SET n={props}
+			REMOVE n:DeletedBrand`,
 		Parameters: neoism.Props{
 			"uuid":  brand.UUID,
 			"props": brandProps,
 		},
 	}
-	queries := []*neoism.CypherQuery{deleteParentRelationship, deleteIdentifiers, writeBrand}
+	queries := []*neoism.CypherQuery{guardMergedQuery(brand.UUID), deleteParentRelationship, deleteIdentifiers, writeBrand}
 
 	if len(brand.ParentUUID) > 0 {
-		writeParent := &neoism.CypherQuery{
-			Statement: `
-                                MERGE (o:Thing {uuid: {uuid}})
-		  	   	MERGE (parentupp:Identifier:UPPIdentifier{value:{paUuid}})
-                            	MERGE (parentupp)-[:IDENTIFIES]->(p:Thing) ON CREATE SET p.uuid = {paUuid}
-		            	MERGE (o)-[:HAS_PARENT]->(p)	`,
-			Parameters: neoism.Props{
-				"paUuid": brand.ParentUUID,
-				"uuid":       brand.UUID,
-			},
-		}
-		queries = append(queries, writeParent)
+		queries = append(queries, guardAgainstCycleQuery(brand))
+		queries = append(queries, s.writeParentQueries(brand)...)
 	}
+	queries = append(queries, adoptPendingChildrenQuery(brand))
 
 	//ADD all the IDENTIFIER nodes and IDENTIFIES relationships
 	for _, alternativeUUID := range brand.AlternativeIdentifiers.TME {
@@ -149,7 +343,8 @@ func (s service) Write(thing interface{}) error {
 		queries = append(queries, alternativeIdentifierQuery)
 	}
 
-	return s.conn.CypherBatch(queries)
+	change := s.newChange(brand.UUID, WriteOperation, "", previous, &brand)
+	return append(queries, changeQuery(change)), change
 }
 
 func createNewIdentifierQuery(uuid string, identifierLabel string, identifierValue string) *neoism.CypherQuery {
@@ -168,15 +363,71 @@ func createNewIdentifierQuery(uuid string, identifierLabel string, identifierVal
 	return query
 }
 
+// DeleteOutcome describes what deleting a brand did. ForeignRelationships counts the relationships to other
+// Things that aren't the brand's own; a conditional delete keeps the node if there are any, and a forced
+// delete lists the ones it dropped.
+type DeleteOutcome struct {
+	UUID                 string                `json:"uuid"`
+	Mode                 DeleteMode            `json:"mode"`
+	LabelsRemoved        bool                  `json:"labelsRemoved"`
+	NodeDeleted          bool                  `json:"nodeDeleted"`
+	ForeignRelationships int                   `json:"foreignRelationships"`
+	IdentifiersRemoved   int                   `json:"identifiersRemoved"`
+	DroppedRelationships []DroppedRelationship `json:"droppedRelationships,omitempty"`
+}
+
 func (s service) Delete(uuid string) (bool, error) {
+	outcome, err := s.DeleteBrand(uuid, ConditionalDelete)
+	return outcome.LabelsRemoved, err
+}
+
+// DeleteBrand deletes the uuid's brand as the mode says. Under ConditionalDelete it removes the brand labels
+// and properties and the parent relationship, then deletes the node and its identifiers if nothing else is
+// related to it. LabelsRemoved is false if the uuid wasn't a brand. Deleting a brand is recorded in its history.
+func (s service) DeleteBrand(uuid string, mode DeleteMode) (DeleteOutcome, error) {
+	stored, err := s.storedBrands([]string{uuid})
+	if err != nil {
+		return DeleteOutcome{UUID: uuid, Mode: mode}, err
+	}
+	var changes []BrandChange
+	var history []*neoism.CypherQuery
+	if previous, exists := stored[uuid]; exists {
+		change := s.newChange(uuid, DeleteOperation, mode, &previous.Brand, nil)
+		changes = append(changes, change)
+		history = append(history, changeQuery(change))
+	}
+
+	outcome, err := s.deleteBrand(uuid, mode, history)
+	if err == nil {
+		s.publish(changes...)
+	}
+	return outcome, err
+}
+
+// deleteBrand makes the delete, recording it in the brand's history in the same batch
+func (s service) deleteBrand(uuid string, mode DeleteMode, history []*neoism.CypherQuery) (DeleteOutcome, error) {
+	switch mode {
+	case SoftDelete:
+		return s.softDelete(uuid, history)
+	case ForcedDelete:
+		return s.forcedDelete(uuid, history)
+	}
+	outcome := DeleteOutcome{UUID: uuid, Mode: ConditionalDelete}
+
+	labelsRemoved := []struct {
+		Count int `json:"labelsRemoved"`
+	}{}
 
 	clearNode := &neoism.CypherQuery{
 		Statement: `
 			MATCH (n:Thing {uuid: {uuid}})
+			WITH n, size([l IN labels(n) WHERE l IN ['Brand', 'Concept', 'Classification', 'DeletedBrand']]) AS labelsRemoved
 			REMOVE n:Brand
 			REMOVE n:Concept
 			REMOVE n:Classification
+			REMOVE n:DeletedBrand
 			SET n={props}
+			RETURN labelsRemoved
 		`,
 		Parameters: neoism.Props{
 			"uuid": uuid,
@@ -184,7 +435,7 @@ func (s service) Delete(uuid string) (bool, error) {
 				"uuid": uuid,
 			},
 		},
-		IncludeStats: true,
+		Result: &labelsRemoved,
 	}
 
 	removeOwnedRelationships := &neoism.CypherQuery{
@@ -197,39 +448,48 @@ func (s service) Delete(uuid string) (bool, error) {
 		},
 	}
 
+	removed := []struct {
+		ForeignRelationships int `json:"foreignRelationships"`
+		IdentifiersRemoved   int `json:"identifiersRemoved"`
+	}{}
+
 	// Please note that this removes the Identifiers if there are no other relationships attached to this
-	// as Identifiers are not a 'Thing' only an Identifier. We also need to consider the relationship to the parent
-	// that this app "owns" it
+	// as Identifiers are not a 'Thing' only an Identifier. The relationship to the parent, which this app
+	// "owns", has already gone by now, so every relationship left to another Thing belongs to someone else.
 	removeNodeIfUnused := &neoism.CypherQuery{
 		Statement: `
 			MATCH (thing:Thing {uuid: {uuid}})
-	 			OPTIONAL MATCH (thing)-[ir:IDENTIFIES]-(id:Identifier)
-	 			OPTIONAL MATCH (thing)-[p:HAS_PARENT]->(t:Thing)
-	 			OPTIONAL MATCH (thing)-[a]-(x:Thing)
-	 			WITH ir, id, thing, p, count(a) AS relCount, count(p) AS parentRelCount
-	 			WHERE (relCount - parentRelCount) = 0
-	 			DELETE ir, id, thing
+			OPTIONAL MATCH (thing)-[a]-(:Thing)
+			WITH thing, count(a) AS foreignRelationships
+			OPTIONAL MATCH (thing)<-[ir:IDENTIFIES]-(id:Identifier)
+			WITH thing, foreignRelationships, collect(ir) AS irs, collect(id) AS ids
+			FOREACH (unused IN CASE WHEN foreignRelationships = 0 THEN [1] ELSE [] END |
+				FOREACH (ir IN irs | DELETE ir)
+				FOREACH (id IN ids | DELETE id)
+				DELETE thing)
+			RETURN foreignRelationships,
+				CASE WHEN foreignRelationships = 0 THEN size(ids) ELSE 0 END AS identifiersRemoved
 		`,
 		Parameters: neoism.Props{
 			"uuid": uuid,
 		},
+		Result: &removed,
 	}
 
-	err := s.conn.CypherBatch([]*neoism.CypherQuery{clearNode,removeOwnedRelationships, removeNodeIfUnused})
-	
-	s1, err := clearNode.Stats()
-
-	if err != nil {
-		return false, err
+	queries := append([]*neoism.CypherQuery{clearNode, removeOwnedRelationships, removeNodeIfUnused}, history...)
+	if err := s.conn.CypherBatch(queries); err != nil {
+		return outcome, err
 	}
 
-	var deleted bool
-	if s1.ContainsUpdates && s1.LabelsRemoved > 0 {
-		deleted = true
+	if len(labelsRemoved) > 0 && labelsRemoved[0].Count > 0 {
+		outcome.LabelsRemoved = true
 	}
-
-	return deleted, err
-
+	if len(removed) > 0 {
+		outcome.ForeignRelationships = removed[0].ForeignRelationships
+		outcome.NodeDeleted = removed[0].ForeignRelationships == 0
+		outcome.IdentifiersRemoved = removed[0].IdentifiersRemoved
+	}
+	return outcome, nil
 }
 
 func (s service) DecodeJSON(dec *json.Decoder) (interface{}, string, error) {
@@ -1,9 +1,11 @@
+//go:build !jenkins
 // +build !jenkins
 
 package brands
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Financial-Times/annotations-rw-neo4j/annotations"
 	"github.com/Financial-Times/base-ft-rw-app-go/baseftrwapp"
@@ -12,86 +14,74 @@ import (
 	"github.com/jmcvetta/neoism"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
-	"reflect"
+	"time"
 )
 
-var defaultTypes = []string{"Thing", "Brand", "Concept", "Classification"}
+// serviceCases are run against every connection returned by testConnections
+var serviceCases = []struct {
+	name string
+	test func(t *testing.T, db neoutils.NeoConnection)
+}{
+	{"CreateNotAllValuesPresent", testCreateNotAllValuesPresent},
+	{"DeleteExistingBrandWithNoRelationshipsRemovesEverything", testDeleteExistingBrandWithNoRelationshipsRemovesEverything},
+	{"CreateAllValuesPresentAndParentNodeCreatedCorrectly", testCreateAllValuesPresentAndParentNodeCreatedCorrectly},
+	{"CreateHandlesSpecialCharacters", testCreateHandlesSpecialCharacters},
+	{"UpdateWillRemovePropertiesNoLongerPresent", testUpdateWillRemovePropertiesNoLongerPresent},
+	{"UpdateWillRemovePropertiesAndIdentifiersNoLongerPresent", testUpdateWillRemovePropertiesAndIdentifiersNoLongerPresent},
+	{"Count", testCount},
+	{"ConnectivityCheck", testConnectivityCheck},
+	{"DeleteWithRelationshipsMaintainsRelationships", testDeleteWithRelationshipsMaintainsRelationships},
+	{"WriteAllWritesEveryBrand", testWriteAllWritesEveryBrand},
+	{"WriteAllRejectsInvalidBrands", testWriteAllRejectsInvalidBrands},
+	{"WriteAllIsolatesFailingBrands", testWriteAllIsolatesFailingBrands},
+	{"WriteAllRejectsRepeatedBrands", testWriteAllRejectsRepeatedBrands},
+	{"WriteSkipsUnchangedBrand", testWriteSkipsUnchangedBrand},
+	{"WriteAllSkipsUnchangedBrands", testWriteAllSkipsUnchangedBrands},
+	{"ExportReturnsEveryBrandInUuidOrder", testExportReturnsEveryBrandInUuidOrder},
+	{"IDsPagesThroughBrandsInOrder", testIDsPagesThroughBrandsInOrder},
+	{"SyncDeletesBrandsMissingFromLoad", testSyncDeletesBrandsMissingFromLoad},
+	{"WriteRejectsIdentifierClaimedByAnotherBrand", testWriteRejectsIdentifierClaimedByAnotherBrand},
+	{"WriteRejectsParentCycle", testWriteRejectsParentCycle},
+	{"RejectedWriteDoesNotFailConcurrentWrite", testRejectedWriteDoesNotFailConcurrentWrite},
+	{"HierarchyReads", testHierarchyReads},
+	{"StrictParentsRejectsMissingParent", testStrictParentsRejectsMissingParent},
+	{"DeferredParentsLinksWhenParentIsWritten", testDeferredParentsLinksWhenParentIsWritten},
+	{"CheckIntegrityReportsAndRepairsAnomalies", testCheckIntegrityReportsAndRepairsAnomalies},
+	{"DeleteBrandReportsOutcome", testDeleteBrandReportsOutcome},
+	{"SoftDeleteHidesBrandUntilRewritten", testSoftDeleteHidesBrandUntilRewritten},
+	{"ForcedDeleteDropsRelationships", testForcedDeleteDropsRelationships},
+	{"MergeRedirectsToCanonicalBrand", testMergeRedirectsToCanonicalBrand},
+	{"MergeKeepsAnnotationsOfEachPlatformVersion", testMergeKeepsAnnotationsOfEachPlatformVersion},
+	{"LookupByIdentifier", testLookupByIdentifier},
+	{"ReadManyBrands", testReadManyBrands},
+	{"SearchBrands", testSearchBrands},
+	{"WriteBrandIfVersionMatches", testWriteBrandIfVersionMatches},
+	{"HistoryRecordsWritesAndDeletes", testHistoryRecordsWritesAndDeletes},
+	{"ReadAsOfRebuildsEarlierBrand", testReadAsOfRebuildsEarlierBrand},
+	{"RollbackRewritesEarlierVersion", testRollbackRewritesEarlierVersion},
+	{"DiffAgainstStoredBrand", testDiffAgainstStoredBrand},
+	{"ChangesArePublishedAsEvents", testChangesArePublishedAsEvents},
+}
 
-const (
-	validSkeletonBrandUuid = "92f4ec09-436d-4092-a88c-96f54e34007d"
-	validSimpleBrandUuid   = "92f4ec09-436d-4092-a88c-96f54e34007c"
-	validChildBrandUuid    = "a806e270-edbc-423f-b8db-d21ae90e06c8"
-	specialCharBrandUuid   = "327af339-39d4-4c7b-8c06-9f80211ea93d"
-	contentUuid            = "3fc9fe3e-af8c-4f7f-961a-e5065392bb31"
-	parentBrandUuid = "92f4ec09-436d-4092-a88c-96f54e34007c"
-)
+func TestService(t *testing.T) {
+	for _, conn := range testConnections() {
+		for _, c := range serviceCases {
+			t.Run(conn.name+"/"+c.name, func(t *testing.T) {
+				db := conn.connect(assert.New(t))
+				checkDbClean(allTestUuids, db, t)
+				c.test(t, db)
+			})
+		}
+	}
+}
 
-var validSkeletonBrand = Brand{
-	UUID:      validSkeletonBrandUuid,
-	PrefLabel: "validSkeletonBrand",
-	AlternativeIdentifiers: alternativeIdentifiers{
-		TME:   []string{"111"},
-		UUIDS: []string{"92f4ec09-436d-4092-a88c-96f54e34007d"},
-	},
-	Types: defaultTypes,
-}
-
-var updatedSkeletonBrand = Brand{
-	UUID:      validSkeletonBrandUuid,
-	PrefLabel: "validSkeletonBrand",
-	AlternativeIdentifiers: alternativeIdentifiers{
-		TME:   []string{"123"},
-		UUIDS: []string{"92f4ec09-436d-4092-a88c-96f54e34007d"},
-	},
-	Types: defaultTypes,
-}
-
-var validSimpleBrand = Brand{
-	UUID:           validSimpleBrandUuid,
-	PrefLabel:      "validSimpleBrand",
-	Strapline:      "Keeping it simple",
-	Description:    "This brand has no parent but otherwise has valid values for all fields",
-	DescriptionXML: "<body>This <i>brand</i> has no parent but otherwise has valid values for all fields</body>",
-	ImageURL:       "http://media.ft.com/validSimpleBrand.png",
-	AlternativeIdentifiers: alternativeIdentifiers{
-		TME:   []string{"123"},
-		UUIDS: []string{"92f4ec09-436d-4092-a88c-96f54e34007c"},
-	},
-	Types: defaultTypes,
-}
-
-var validChildBrand = Brand{
-	UUID:           validChildBrandUuid,
-	ParentUUID:     parentBrandUuid,
-	PrefLabel:      "validChildBrand",
-	Strapline:      "My parent is simple",
-	Description:    "This brand has a parent and valid values for all fields",
-	DescriptionXML: "<body>This <i>brand</i> has a parent and valid values for all fields</body>",
-	ImageURL:       "http://media.ft.com/validChildBrand.png",
-	AlternativeIdentifiers: alternativeIdentifiers{
-		TME:   []string{"123123"},
-		UUIDS: []string{"a806e270-edbc-423f-b8db-d21ae90e06c8"},
-	},
-	Types: defaultTypes,
-	Aliases: []string{"SomeWonkyBrand", "AnotherAliasForABrand"},
-}
-
-var specialCharBrand = Brand{
-	UUID:        specialCharBrandUuid,
-	PrefLabel:   "specialCharBrand",
-	Description: "This brand has a heart \u2665 and smiley \u263A",
-	AlternativeIdentifiers: alternativeIdentifiers{
-		TME:   []string{"1111"},
-		UUIDS: []string{"327af339-39d4-4c7b-8c06-9f80211ea93d"},
-	},
-	Types: defaultTypes,
-}
-
-func TestCreateNotAllValuesPresent(t *testing.T) {
-	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
+func testCreateNotAllValuesPresent(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
 	brandsDriver := getCypherDriver(db)
 
 	defer cleanDB([]string{validSkeletonBrandUuid}, db, t, assert)
@@ -100,9 +90,8 @@ func TestCreateNotAllValuesPresent(t *testing.T) {
 	readBrandAndCompare(validSkeletonBrand, t, db)
 }
 
-func TestDeleteExistingBrandWithNoRelationshipsRemovesEverything(t *testing.T) {
+func testDeleteExistingBrandWithNoRelationshipsRemovesEverything(t *testing.T, db neoutils.NeoConnection) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
 	brandsDriver := getCypherDriver(db)
 
 	defer cleanDB([]string{validSimpleBrand.UUID}, db, t, assert)
@@ -121,9 +110,8 @@ func TestDeleteExistingBrandWithNoRelationshipsRemovesEverything(t *testing.T) {
 	assert.False(doesThingExistAtAll(validSimpleBrand.UUID, db, t, assert), "Failed to delete brand")
 }
 
-func TestCreateAllValuesPresentAndParentNodeCreatedCorrectly(t *testing.T) {
+func testCreateAllValuesPresentAndParentNodeCreatedCorrectly(t *testing.T, db neoutils.NeoConnection) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
 	brandsDriver := getCypherDriver(db)
 
 	defer cleanDB([]string{parentBrandUuid, validChildBrand.UUID}, db, t, assert)
@@ -137,9 +125,8 @@ func TestCreateAllValuesPresentAndParentNodeCreatedCorrectly(t *testing.T) {
 
 }
 
-func TestCreateHandlesSpecialCharacters(t *testing.T) {
+func testCreateHandlesSpecialCharacters(t *testing.T, db neoutils.NeoConnection) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
 	brandsDriver := getCypherDriver(db)
 
 	defer cleanDB([]string{specialCharBrand.UUID}, db, t, assert)
@@ -149,9 +136,8 @@ func TestCreateHandlesSpecialCharacters(t *testing.T) {
 	readBrandAndCompare(specialCharBrand, t, db)
 }
 
-func TestUpdateWillRemovePropertiesNoLongerPresent(t *testing.T) {
+func testUpdateWillRemovePropertiesNoLongerPresent(t *testing.T, db neoutils.NeoConnection) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
 	brandsDriver := getCypherDriver(db)
 
 	defer cleanDB([]string{validSimpleBrand.UUID}, db, t, assert)
@@ -167,9 +153,8 @@ func TestUpdateWillRemovePropertiesNoLongerPresent(t *testing.T) {
 	readBrandAndCompare(myBrand, t, db)
 }
 
-func TestUpdateWillRemovePropertiesAndIdentifiersNoLongerPresent(t *testing.T) {
+func testUpdateWillRemovePropertiesAndIdentifiersNoLongerPresent(t *testing.T, db neoutils.NeoConnection) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
 	brandsDriver := getCypherDriver(db)
 
 	defer cleanDB([]string{updatedSkeletonBrand.UUID, validSimpleBrand.UUID}, db, t, assert)
@@ -181,9 +166,8 @@ func TestUpdateWillRemovePropertiesAndIdentifiersNoLongerPresent(t *testing.T) {
 	readBrandAndCompare(updatedSkeletonBrand, t, db)
 }
 
-func TestCount(t *testing.T) {
+func testCount(t *testing.T, db neoutils.NeoConnection) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
 	brandsDriver := getCypherDriver(db)
 
 	defer cleanDB([]string{updatedSkeletonBrand.UUID, validSimpleBrand.UUID}, db, t, assert)
@@ -201,18 +185,16 @@ func TestCount(t *testing.T) {
 	assert.NoError(err, "An unexpected error occurred during count")
 }
 
-func TestConnectivityCheck(t *testing.T) {
+func testConnectivityCheck(t *testing.T, db neoutils.NeoConnection) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
 	brandsDriver := getCypherDriver(db)
 
 	err := brandsDriver.Check()
 	assert.NoError(err, "Check connectivity failed")
 }
 
-func TestDeleteWithRelationshipsMaintainsRelationships(t *testing.T) {
+func testDeleteWithRelationshipsMaintainsRelationships(t *testing.T, db neoutils.NeoConnection) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
 	brandsDriver := getCypherDriver(db)
 
 	defer cleanDB([]string{validSimpleBrandUuid, contentUuid}, db, t, assert)
@@ -235,14 +217,1156 @@ func TestDeleteWithRelationshipsMaintainsRelationships(t *testing.T) {
 		"Unable to find a Thing with any Identifiers, uuid: %s", validSimpleBrandUuid)
 }
 
+func testWriteAllWritesEveryBrand(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSkeletonBrandUuid, validSimpleBrandUuid, validChildBrandUuid, specialCharBrandUuid}, db, t, assert)
+
+	results := brandsDriver.WriteAll([]Brand{validSimpleBrand, validChildBrand, validSkeletonBrand, specialCharBrand})
+
+	assert.Equal([]WriteResult{
+		{UUID: validSimpleBrandUuid, Status: Written},
+		{UUID: validChildBrandUuid, Status: Written},
+		{UUID: validSkeletonBrandUuid, Status: Written},
+		{UUID: specialCharBrandUuid, Status: Written},
+	}, results)
+	readBrandAndCompare(validSimpleBrand, t, db)
+	readBrandAndCompare(validChildBrand, t, db)
+	readBrandAndCompare(validSkeletonBrand, t, db)
+	readBrandAndCompare(specialCharBrand, t, db)
+}
+
+func testWriteAllRejectsInvalidBrands(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, specialCharBrandUuid}, db, t, assert)
+
+	missingOwnUUID := specialCharBrand
+	missingOwnUUID.AlternativeIdentifiers = alternativeIdentifiers{UUIDS: []string{validSkeletonBrandUuid}}
+
+	results := brandsDriver.WriteAll([]Brand{{PrefLabel: "no uuid"}, validSimpleBrand, missingOwnUUID})
+
+	assert.Equal(Rejected, results[0].Status)
+	assert.Equal(Written, results[1].Status)
+	assert.Equal(Rejected, results[2].Status)
+	assert.Equal(specialCharBrandUuid, results[2].UUID)
+
+	readBrandAndCompare(validSimpleBrand, t, db)
+	_, found, err := brandsDriver.Read(specialCharBrandUuid)
+	assert.NoError(err)
+	assert.False(found, "Rejected brand should not have been written")
+}
+
+func testWriteAllRejectsRepeatedBrands(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+	changed := validSimpleBrand
+	changed.Strapline = "Keeping it simpler"
+
+	results := brandsDriver.WriteAll([]Brand{changed, validSimpleBrand})
+	assert.Equal(Written, results[0].Status)
+	assert.Equal(Rejected, results[1].Status)
+	readBrandAndCompare(changed, t, db)
+
+	changes, err := brandsDriver.History(validSimpleBrandUuid)
+	assert.NoError(err)
+	if assert.Len(changes, 2) {
+		assert.Equal(validSimpleBrand.Strapline, changes[1].Previous.Strapline)
+	}
+}
+
+func testWriteAllIsolatesFailingBrands(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := NewCypherBrandsService(db, 1024, PlaceholderParents)
+	assert.NoError(brandsDriver.Initialise())
+
+	defer cleanDB([]string{validSimpleBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	// both brands claim TME identifier 123, so they can't be written in the same batch
+	results := brandsDriver.WriteAll([]Brand{validSimpleBrand, updatedSkeletonBrand})
+
+	assert.Equal(Written, results[0].Status)
+	assert.Equal(Rejected, results[1].Status)
+	assert.Contains(results[1].Reason, validSimpleBrandUuid)
+	readBrandAndCompare(validSimpleBrand, t, db)
+}
+
+func testWriteSkipsUnchangedBrand(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{parentBrandUuid, validChildBrandUuid}, db, t, assert)
+
+	status, err := brandsDriver.WriteBrand(validChildBrand)
+	assert.NoError(err)
+	assert.Equal(Written, status)
+
+	reordered := validChildBrand
+	reordered.Aliases = []string{validChildBrand.Aliases[1], validChildBrand.Aliases[0]}
+	status, err = brandsDriver.WriteBrand(reordered)
+	assert.NoError(err)
+	assert.Equal(Unchanged, status, "Alias order should not count as a change")
+
+	noParent := validChildBrand
+	noParent.ParentUUID = ""
+	status, err = brandsDriver.WriteBrand(noParent)
+	assert.NoError(err)
+	assert.Equal(Written, status)
+	readBrandAndCompare(noParent, t, db)
+}
+
+func testWriteAllSkipsUnchangedBrands(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, specialCharBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand), "Failed to write brand")
+
+	updated := specialCharBrand
+	results := brandsDriver.WriteAll([]Brand{validSimpleBrand, updated})
+	assert.Equal(Unchanged, results[0].Status)
+	assert.Equal(Written, results[1].Status)
+
+	updated.Description = "Now without a heart"
+	results = brandsDriver.WriteAll([]Brand{validSimpleBrand, updated})
+	assert.Equal(Unchanged, results[0].Status)
+	assert.Equal(Written, results[1].Status)
+	readBrandAndCompare(updated, t, db)
+}
+
+func testExportReturnsEveryBrandInUuidOrder(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSkeletonBrandUuid, validSimpleBrandUuid, validChildBrandUuid, specialCharBrandUuid}, db, t, assert)
+
+	expected := []Brand{specialCharBrand, validSimpleBrand, validSkeletonBrand, validChildBrand}
+	for _, brand := range expected {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+
+	// page sizes that divide the brands exactly and that don't
+	for _, pageSize := range []int{2, 3} {
+		var exported []Brand
+		err := brandsDriver.Export(pageSize, func(brand Brand) error {
+			exported = append(exported, brand)
+			return nil
+		})
+		assert.NoError(err)
+
+		if assert.Len(exported, len(expected)) {
+			for i, brand := range expected {
+				sort.Strings(exported[i].Types)
+				sort.Strings(exported[i].Aliases)
+				sort.Strings(brand.Types)
+				sort.Strings(brand.Aliases)
+				assert.Equal(brand, exported[i], "Page size %d", pageSize)
+			}
+		}
+	}
+}
+
+func testIDsPagesThroughBrandsInOrder(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSkeletonBrandUuid, validSimpleBrandUuid, parentBrandUuid, validChildBrandUuid, specialCharBrandUuid}, db, t, assert)
+
+	for _, brand := range []Brand{validSkeletonBrand, validChildBrand, specialCharBrand} {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+
+	ids, err := brandsDriver.IDs("", 2)
+	assert.NoError(err)
+	assert.Equal([]string{specialCharBrandUuid, validSkeletonBrandUuid}, ids)
+
+	// the parent placeholder written for the child brand isn't a brand, so isn't listed
+	ids, err = brandsDriver.IDs(ids[1], 2)
+	assert.NoError(err)
+	assert.Equal([]string{validChildBrandUuid}, ids)
+
+	ids, err = brandsDriver.IDs(validChildBrandUuid, 2)
+	assert.NoError(err)
+	assert.Empty(ids)
+}
+
+func testSyncDeletesBrandsMissingFromLoad(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSkeletonBrandUuid, validSimpleBrandUuid, specialCharBrandUuid}, db, t, assert)
+
+	for _, brand := range []Brand{validSkeletonBrand, validSimpleBrand, specialCharBrand} {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+
+	report, err := brandsDriver.Sync([]string{validSimpleBrandUuid}, 1, false)
+	assert.Equal(TooManyDeletionsError{Count: 2, Max: 1}, err)
+	assert.Equal([]string{specialCharBrandUuid, validSkeletonBrandUuid}, report.Missing)
+
+	report, err = brandsDriver.Sync([]string{validSimpleBrandUuid, specialCharBrandUuid}, 1, false)
+	assert.NoError(err)
+	assert.Equal(SyncReport{MaxDeletions: 1, Stored: 3, Missing: []string{validSkeletonBrandUuid}}, report)
+
+	assert.False(doesThingExistAtAll(validSkeletonBrandUuid, db, t, assert), "Failed to delete brand missing from load")
+	readBrandAndCompare(validSimpleBrand, t, db)
+	readBrandAndCompare(specialCharBrand, t, db)
+}
+
+func testWriteRejectsIdentifierClaimedByAnotherBrand(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand), "Failed to write brand")
+
+	claimsSimpleUUID := validSkeletonBrand
+	claimsSimpleUUID.AlternativeIdentifiers.UUIDS = []string{validSkeletonBrandUuid, validSimpleBrandUuid}
+
+	for _, brand := range []Brand{updatedSkeletonBrand, claimsSimpleUUID} {
+		err := brandsDriver.Write(brand)
+		assert.IsType(IdentifierConflictError{}, err)
+		_, found, readErr := brandsDriver.Read(validSkeletonBrandUuid)
+		assert.NoError(readErr)
+		assert.False(found, "Brand with conflicting identifiers should not have been written")
+	}
+
+	err := brandsDriver.Write(updatedSkeletonBrand)
+	assert.Equal(IdentifierConflictError{
+		UUID:      validSkeletonBrandUuid,
+		Conflicts: []IdentifierConflict{{Authority: "TME", Value: "123", OwnerUUID: validSimpleBrandUuid}},
+	}, err)
+
+	// rewriting the owner itself is not a conflict
+	updatedSimpleBrand := validSimpleBrand
+	updatedSimpleBrand.PrefLabel = "Renamed"
+	assert.NoError(brandsDriver.Write(updatedSimpleBrand))
+	readBrandAndCompare(updatedSimpleBrand, t, db)
+}
+
+func testWriteRejectsParentCycle(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	grandchild := validSkeletonBrand
+	grandchild.ParentUUID = validChildBrandUuid
+	for _, brand := range []Brand{validSimpleBrand, validChildBrand, grandchild} {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+
+	ownChild := validSimpleBrand
+	ownChild.ParentUUID = validChildBrandUuid
+	assert.Equal(CycleError{
+		UUID: validSimpleBrandUuid,
+		Path: []string{validSimpleBrandUuid, validChildBrandUuid, validSimpleBrandUuid},
+	}, brandsDriver.Write(ownChild))
+
+	ownGrandchild := validSimpleBrand
+	ownGrandchild.ParentUUID = validSkeletonBrandUuid
+	assert.Equal(CycleError{
+		UUID: validSimpleBrandUuid,
+		Path: []string{validSimpleBrandUuid, validSkeletonBrandUuid, validChildBrandUuid, validSimpleBrandUuid},
+	}, brandsDriver.Write(ownGrandchild))
+
+	readBrandAndCompare(validSimpleBrand, t, db)
+
+	// moving a brand within its own branch is fine
+	siblingOfParent := grandchild
+	siblingOfParent.ParentUUID = validSimpleBrandUuid
+	assert.NoError(brandsDriver.Write(siblingOfParent))
+	readBrandAndCompare(siblingOfParent, t, db)
+}
+
+func testRejectedWriteDoesNotFailConcurrentWrite(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	for _, brand := range []Brand{validSimpleBrand, validChildBrand} {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+
+	ownChild := validSimpleBrand
+	ownChild.ParentUUID = validChildBrandUuid
+	var wg sync.WaitGroup
+	var cycleErr, skeletonErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		cycleErr = brandsDriver.Write(ownChild)
+	}()
+	go func() {
+		defer wg.Done()
+		skeletonErr = brandsDriver.Write(validSkeletonBrand)
+	}()
+	wg.Wait()
+
+	assert.IsType(CycleError{}, cycleErr)
+	assert.NoError(skeletonErr)
+	readBrandAndCompare(validSimpleBrand, t, db)
+	readBrandAndCompare(validSkeletonBrand, t, db)
+}
+
+func testHierarchyReads(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid, validSkeletonBrandUuid, specialCharBrandUuid}, db, t, assert)
+
+	grandchild := validSkeletonBrand
+	grandchild.ParentUUID = validChildBrandUuid
+	secondChild := specialCharBrand
+	secondChild.ParentUUID = validSimpleBrandUuid
+	for _, brand := range []Brand{validSimpleBrand, validChildBrand, grandchild, secondChild} {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+
+	ancestors, found, err := brandsDriver.Ancestors(validSkeletonBrandUuid)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal([]string{validChildBrandUuid, validSimpleBrandUuid}, brandUUIDs(ancestors))
+	assert.Equal(validChildBrand.PrefLabel, ancestors[0].PrefLabel)
+
+	ancestors, found, err = brandsDriver.Ancestors(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Empty(ancestors)
+
+	children, found, err := brandsDriver.Children(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal([]string{specialCharBrandUuid, validChildBrandUuid}, brandUUIDs(children))
+
+	children, found, err = brandsDriver.Children(validSkeletonBrandUuid)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Empty(children)
+
+	tree, found, err := brandsDriver.Descendants(validSimpleBrandUuid, -1)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(validSimpleBrand.PrefLabel, tree.PrefLabel)
+	assert.Len(tree.Children, 2)
+	assert.Equal(specialCharBrandUuid, tree.Children[0].UUID)
+	assert.Empty(tree.Children[0].Children)
+	assert.Equal(validChildBrandUuid, tree.Children[1].UUID)
+	assert.Len(tree.Children[1].Children, 1)
+	assert.Equal(validSkeletonBrandUuid, tree.Children[1].Children[0].UUID)
+
+	tree, found, err = brandsDriver.Descendants(validSimpleBrandUuid, 1)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Len(tree.Children, 2)
+	assert.Empty(tree.Children[1].Children)
+
+	_, found, err = brandsDriver.Ancestors(contentUuid)
+	assert.NoError(err)
+	assert.False(found)
+	_, found, err = brandsDriver.Children(contentUuid)
+	assert.NoError(err)
+	assert.False(found)
+	_, found, err = brandsDriver.Descendants(contentUuid, -1)
+	assert.NoError(err)
+	assert.False(found)
+
+	_, err = brandsDriver.DeleteBrand(validChildBrandUuid, SoftDelete)
+	assert.NoError(err)
+	ancestors, found, err = brandsDriver.Ancestors(validSkeletonBrandUuid)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Empty(ancestors, "The walk should stop at a soft deleted parent")
+}
+
+func testStrictParentsRejectsMissingParent(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := NewCypherBrandsService(db, testBatchSize, StrictParents)
+	assert.NoError(brandsDriver.Initialise())
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid}, db, t, assert)
+
+	assert.Equal(MissingParentError{UUID: validChildBrandUuid, ParentUUID: parentBrandUuid}, brandsDriver.Write(validChildBrand))
+	assert.False(doesThingExistAtAll(parentBrandUuid, db, t, assert), "No placeholder parent should have been created")
+
+	results := brandsDriver.WriteAll([]Brand{validChildBrand, validSimpleBrand})
+	assert.Equal(Rejected, results[0].Status)
+	assert.Equal(Written, results[1].Status)
+
+	assert.NoError(brandsDriver.Write(validChildBrand))
+	readBrandAndCompare(validChildBrand, t, db)
+}
+
+func testDeferredParentsLinksWhenParentIsWritten(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := NewCypherBrandsService(db, testBatchSize, DeferredParents)
+	assert.NoError(brandsDriver.Initialise())
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validChildBrand))
+	readBrandAndCompare(validChildBrand, t, db)
+	assert.False(doesThingExistAtAll(parentBrandUuid, db, t, assert), "No placeholder parent should have been created")
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+	children, found, err := brandsDriver.Children(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal([]string{validChildBrandUuid}, brandUUIDs(children))
+	readBrandAndCompare(validChildBrand, t, db)
+
+	status, err := brandsDriver.WriteBrand(validChildBrand)
+	assert.NoError(err)
+	assert.Equal(Unchanged, status)
+}
+
+func testCheckIntegrityReportsAndRepairsAnomalies(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid, specialCharBrandUuid}, db, t, assert)
+
+	// the child's parent hasn't been written, so it gets a placeholder
+	assert.NoError(brandsDriver.Write(validChildBrand))
+	assert.NoError(brandsDriver.Write(specialCharBrand))
+	breakIntegrity(specialCharBrandUuid, "dangling-tme", db, assert)
+
+	placeholder := PlaceholderParent{UUID: parentBrandUuid, Children: []string{validChildBrandUuid}}
+	dangling := DanglingIdentifier{Authority: "TME", Value: "dangling-tme"}
+
+	report, err := brandsDriver.CheckIntegrity(false)
+	assert.NoError(err)
+	assert.False(report.Repaired)
+	assert.Contains(report.PlaceholderParents, placeholder)
+	assert.Contains(report.DanglingIdentifiers, dangling)
+	assert.Contains(report.MissingOwnIdentifier, specialCharBrandUuid)
+
+	report, err = brandsDriver.CheckIntegrity(false)
+	assert.NoError(err)
+	assert.Contains(report.PlaceholderParents, placeholder, "Reporting alone should change nothing")
+
+	report, err = brandsDriver.CheckIntegrity(true)
+	assert.NoError(err)
+	assert.True(report.Repaired)
+	assert.Contains(report.PlaceholderParents, placeholder)
+
+	report, err = brandsDriver.CheckIntegrity(false)
+	assert.NoError(err)
+	assert.NotContains(report.PlaceholderParents, placeholder)
+	assert.NotContains(report.DanglingIdentifiers, dangling)
+	assert.NotContains(report.MissingOwnIdentifier, specialCharBrandUuid)
+
+	assert.False(doesThingExistAtAll(parentBrandUuid, db, t, assert), "Placeholder parent should have been removed")
+	readBrandAndCompare(validChildBrand, t, db)
+	readBrandAndCompare(specialCharBrand, t, db)
+
+	// the released child is linked again once its parent is written
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+	children, _, err := brandsDriver.Children(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.Equal([]string{validChildBrandUuid}, brandUUIDs(children))
+}
+
+// breakIntegrity removes the brand's identifier for its own uuid and adds a TME identifier that identifies nothing
+func breakIntegrity(uuid string, danglingTME string, db neoutils.NeoConnection, assert *assert.Assertions) {
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{
+		{
+			Statement: `
+				MATCH (:Brand {uuid:{uuid}})<-[r:IDENTIFIES]-(i:UPPIdentifier {value:{uuid}})
+				DELETE r, i`,
+			Parameters: neoism.Props{"uuid": uuid},
+		},
+		{
+			Statement:  `CREATE (i:Identifier:TMEIdentifier {value:{value}})`,
+			Parameters: neoism.Props{"value": danglingTME},
+		},
+	}))
+}
+
+func testDeleteBrandReportsOutcome(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, specialCharBrandUuid, contentUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand), "Failed to write brand")
+	assert.NoError(brandsDriver.Write(specialCharBrand), "Failed to write brand")
+	writeContent(assert, db)
+	writeAnnotation(assert, db)
+
+	outcome, err := brandsDriver.DeleteBrand(validSimpleBrandUuid, ConditionalDelete)
+	assert.NoError(err)
+	assert.Equal(DeleteOutcome{UUID: validSimpleBrandUuid, Mode: ConditionalDelete, LabelsRemoved: true, ForeignRelationships: 1}, outcome)
+
+	outcome, err = brandsDriver.DeleteBrand(specialCharBrandUuid, ConditionalDelete)
+	assert.NoError(err)
+	assert.Equal(DeleteOutcome{UUID: specialCharBrandUuid, Mode: ConditionalDelete, LabelsRemoved: true, NodeDeleted: true, IdentifiersRemoved: 2}, outcome)
+
+	outcome, err = brandsDriver.DeleteBrand(specialCharBrandUuid, ConditionalDelete)
+	assert.NoError(err)
+	assert.Equal(DeleteOutcome{UUID: specialCharBrandUuid, Mode: ConditionalDelete}, outcome)
+}
+
+func testSoftDeleteHidesBrandUntilRewritten(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand), "Failed to write brand")
+
+	outcome, err := brandsDriver.DeleteBrand(validSimpleBrandUuid, SoftDelete)
+	assert.NoError(err)
+	assert.Equal(DeleteOutcome{UUID: validSimpleBrandUuid, Mode: SoftDelete, LabelsRemoved: true}, outcome)
+
+	_, found, err := brandsDriver.Read(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.False(found, "Soft deleted brand should not be read")
+	count, err := brandsDriver.Count()
+	assert.NoError(err)
+	assert.Equal(0, count)
+	assert.True(doesThingExistWithIdentifiers(validSimpleBrandUuid, db, t, assert), "Soft deleted brand should keep its node and identifiers")
+
+	outcome, err = brandsDriver.DeleteBrand(validSimpleBrandUuid, SoftDelete)
+	assert.NoError(err)
+	assert.False(outcome.LabelsRemoved, "A soft deleted brand can't be soft deleted again")
+
+	status, err := brandsDriver.WriteBrand(validSimpleBrand)
+	assert.NoError(err)
+	assert.Equal(Written, status)
+	readBrandAndCompare(validSimpleBrand, t, db)
+}
+
+func testForcedDeleteDropsRelationships(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid, contentUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand), "Failed to write brand")
+	assert.NoError(brandsDriver.Write(validChildBrand), "Failed to write brand")
+	writeContent(assert, db)
+	writeAnnotation(assert, db)
+
+	outcome, err := brandsDriver.DeleteBrand(validSimpleBrandUuid, ForcedDelete)
+	assert.NoError(err)
+	assert.True(outcome.LabelsRemoved)
+	assert.True(outcome.NodeDeleted)
+	assert.Equal(2, outcome.IdentifiersRemoved)
+	assert.Equal(2, outcome.ForeignRelationships)
+	dropped := map[string]DroppedRelationship{}
+	for _, rel := range outcome.DroppedRelationships {
+		dropped[rel.UUID] = rel
+	}
+	assert.Equal("IS_CLASSIFIED_BY", dropped[contentUuid].Type)
+	assert.False(dropped[contentUuid].Outgoing)
+	assert.Equal("HAS_PARENT", dropped[validChildBrandUuid].Type)
+	assert.False(dropped[validChildBrandUuid].Outgoing)
+
+	assert.False(doesThingExistAtAll(validSimpleBrandUuid, db, t, assert), "Forced delete should remove the node")
+
+	outcome, err = brandsDriver.DeleteBrand(validSimpleBrandUuid, ForcedDelete)
+	assert.NoError(err)
+	assert.Equal(DeleteOutcome{UUID: validSimpleBrandUuid, Mode: ForcedDelete}, outcome)
+
+	outcome, err = brandsDriver.DeleteBrand(contentUuid, ForcedDelete)
+	assert.NoError(err)
+	assert.Equal(DeleteOutcome{UUID: contentUuid, Mode: ForcedDelete}, outcome)
+	assert.True(doesThingExistAtAll(contentUuid, db, t, assert), "Forced delete should only remove brands")
+}
+
+func testMergeRedirectsToCanonicalBrand(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid, validSkeletonBrandUuid, specialCharBrandUuid, contentUuid}, db, t, assert)
+
+	for _, brand := range []Brand{validSimpleBrand, validChildBrand, validSkeletonBrand, specialCharBrand} {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+	writeContent(assert, db)
+	writeAnnotation(assert, db)
+	_, versionBefore, _, err := brandsDriver.ReadVersion(validSkeletonBrandUuid)
+	assert.NoError(err)
+
+	sink := &recordingSink{}
+	result, err := brandsDriver.WithEventSink(sink).Merge(validSimpleBrandUuid, validSkeletonBrandUuid)
+	assert.NoError(err)
+	assert.Equal(2, result.Identifiers)
+	assert.Len(result.Relationships, 2, "Expected the child's parent relationship and the annotation to move")
+	assert.Equal(1, result.Relationships["HAS_PARENT"])
+
+	brand, found, err := brandsDriver.Read(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.True(found)
+	canonical := brand.(Brand)
+	assert.Equal(validSkeletonBrandUuid, canonical.UUID)
+	assert.Equal(validSkeletonBrand.PrefLabel, canonical.PrefLabel)
+	assert.ElementsMatch([]string{"111", "123"}, canonical.AlternativeIdentifiers.TME)
+	assert.ElementsMatch([]string{validSimpleBrandUuid, validSkeletonBrandUuid}, canonical.AlternativeIdentifiers.UUIDS)
+
+	children, _, err := brandsDriver.Children(validSkeletonBrandUuid)
+	assert.NoError(err)
+	assert.Equal([]string{validChildBrandUuid}, brandUUIDs(children))
+
+	count, err := brandsDriver.Count()
+	assert.NoError(err)
+	assert.Equal(3, count)
+
+	_, versionAfter, _, err := brandsDriver.ReadVersion(validSkeletonBrandUuid)
+	assert.NoError(err)
+	assert.NotEqual(versionBefore, versionAfter, "The merge should give the target a new version")
+
+	history, err := brandsDriver.History(validSimpleBrandUuid)
+	assert.NoError(err)
+	if assert.Len(history, 2) {
+		assert.Equal(MergeOperation, history[1].Operation)
+		assert.Equal(validSkeletonBrandUuid, history[1].MergedInto)
+		assert.Nil(history[1].Current)
+	}
+	history, err = brandsDriver.History(validSkeletonBrandUuid)
+	assert.NoError(err)
+	if assert.Len(history, 2) {
+		assert.Equal(MergeOperation, history[1].Operation)
+		assert.Equal([]string{"alternativeIdentifiers"}, history[1].ChangedFields)
+		assert.ElementsMatch([]string{"111", "123"}, history[1].Current.AlternativeIdentifiers.TME)
+	}
+	if assert.Len(sink.events, 2) {
+		assert.Equal(validSimpleBrandUuid, sink.events[0].UUID)
+		assert.Equal(validSkeletonBrandUuid, sink.events[0].MergedInto)
+		assert.Equal(validSkeletonBrandUuid, sink.events[1].UUID)
+		assert.Equal(MergeOperation, sink.events[1].Type)
+	}
+
+	assert.Equal(MergedBrandError{UUID: validSimpleBrandUuid, Target: validSkeletonBrandUuid}, brandsDriver.Write(validSimpleBrand))
+	results := brandsDriver.WriteAll([]Brand{validSimpleBrand})
+	assert.Equal(Rejected, results[0].Status)
+	_, err = brandsDriver.Rollback(validSimpleBrandUuid, 1)
+	assert.IsType(MergedBrandError{}, err)
+	_, err = brandsDriver.Rollback(validSimpleBrandUuid, 2)
+	assert.IsType(RollbackError{}, err)
+
+	// rewriting the target keeps the identifiers merged into it
+	edited := validSkeletonBrand
+	edited.Strapline = "Merged"
+	assert.NoError(brandsDriver.Write(edited))
+	brand, _, err = brandsDriver.Read(validSkeletonBrandUuid)
+	assert.NoError(err)
+	assert.Equal("Merged", brand.(Brand).Strapline)
+	assert.ElementsMatch([]string{"111", "123"}, brand.(Brand).AlternativeIdentifiers.TME)
+	assert.ElementsMatch([]string{validSimpleBrandUuid, validSkeletonBrandUuid}, brand.(Brand).AlternativeIdentifiers.UUIDS)
+
+	_, err = brandsDriver.Merge(validSimpleBrandUuid, specialCharBrandUuid)
+	assert.IsType(MergeError{}, err, "A merged brand can't be merged again")
+	_, err = brandsDriver.Merge(specialCharBrandUuid, specialCharBrandUuid)
+	assert.IsType(MergeError{}, err)
+	_, err = brandsDriver.Merge(validSkeletonBrandUuid, validChildBrandUuid)
+	assert.IsType(MergeError{}, err, "A brand can't be merged into its own descendant")
+
+	// merging the canonical brand again carries the earlier redirect along
+	_, err = brandsDriver.Merge(validSkeletonBrandUuid, specialCharBrandUuid)
+	assert.NoError(err)
+	brand, found, err = brandsDriver.Read(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(specialCharBrandUuid, brand.(Brand).UUID)
+}
+
+func testMergeKeepsAnnotationsOfEachPlatformVersion(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validSkeletonBrandUuid, contentUuid}, db, t, assert)
+
+	for _, brand := range []Brand{validSimpleBrand, validSkeletonBrand} {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+	annotateVersion(assert, db, validSimpleBrandUuid, "v1")
+	annotateVersion(assert, db, validSimpleBrandUuid, "v2")
+	annotateVersion(assert, db, validSkeletonBrandUuid, "v2")
+
+	result, err := brandsDriver.Merge(validSimpleBrandUuid, validSkeletonBrandUuid)
+	assert.NoError(err)
+	assert.Equal(map[string]int{"IS_CLASSIFIED_BY": 1}, result.Relationships, "Expected only the v1 annotation to be moved")
+	assert.Equal([]string{"v1", "v2"}, annotationVersions(assert, db, validSkeletonBrandUuid))
+}
+
+func testLookupByIdentifier(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand), "Failed to write brand")
+	assert.NoError(brandsDriver.Write(validSkeletonBrand), "Failed to write brand")
+
+	matches, err := brandsDriver.Lookup("TME", []string{"123", "999", "111"})
+	assert.NoError(err)
+	assert.Len(matches, 2)
+	assert.Equal("111", matches[0].Value)
+	assert.Equal(validSkeletonBrandUuid, matches[0].UUID)
+	assert.Equal("123", matches[1].Value)
+	assert.Equal(validSimpleBrandUuid, matches[1].UUID)
+	assert.Equal(validSimpleBrand.PrefLabel, matches[1].Brand.PrefLabel)
+
+	matches, err = brandsDriver.Lookup("UPP", []string{validSimpleBrandUuid})
+	assert.NoError(err)
+	assert.Equal([]string{validSimpleBrandUuid}, []string{matches[0].UUID})
+
+	matches, err = brandsDriver.Lookup("UPP", []string{"123"})
+	assert.NoError(err)
+	assert.Empty(matches, "TME values shouldn't match UPP identifiers")
+
+	_, err = brandsDriver.Lookup("ISBN", []string{"123"})
+	assert.Equal(UnknownAuthorityError{"ISBN"}, err)
+}
+
+func testReadManyBrands(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	for _, brand := range []Brand{validSimpleBrand, validChildBrand, validSkeletonBrand} {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+	_, err := brandsDriver.Merge(validSkeletonBrandUuid, validSimpleBrandUuid)
+	assert.NoError(err)
+
+	brands, err := brandsDriver.ReadMany([]string{validChildBrandUuid, validSkeletonBrandUuid, specialCharBrandUuid})
+	assert.NoError(err)
+	assert.Len(brands, 3)
+	if assert.NotNil(brands[validChildBrandUuid]) {
+		assert.Equal(validChildBrand.PrefLabel, brands[validChildBrandUuid].PrefLabel)
+		assert.Equal(validSimpleBrandUuid, brands[validChildBrandUuid].ParentUUID)
+	}
+	if assert.NotNil(brands[validSkeletonBrandUuid], "Expected the merged brand to be followed") {
+		assert.Equal(validSimpleBrandUuid, brands[validSkeletonBrandUuid].UUID)
+	}
+	assert.Contains(brands, specialCharBrandUuid)
+	assert.Nil(brands[specialCharBrandUuid])
+
+	brands, err = brandsDriver.ReadMany(nil)
+	assert.NoError(err)
+	assert.Empty(brands)
+}
+
+func testSearchBrands(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+	assert.NoError(brandsDriver.Initialise())
+	if fake, ok := db.(*fakeNeoConnection); ok {
+		assert.Contains(fake.indexes["Brand"], "prefLabelLower")
+	}
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid, validSkeletonBrandUuid, specialCharBrandUuid}, db, t, assert)
+
+	for _, brand := range []Brand{validSimpleBrand, validChildBrand, validSkeletonBrand, specialCharBrand} {
+		assert.NoError(brandsDriver.Write(brand), "Failed to write brand")
+	}
+
+	results, err := brandsDriver.Search("VALIDSIMPLEBRAND", 0, 10)
+	assert.NoError(err)
+	if assert.Len(results, 1) {
+		assert.Equal(ExactMatch, results[0].Match)
+		assert.Equal(validSimpleBrand.PrefLabel, results[0].Term)
+		assert.Equal(validSimpleBrandUuid, results[0].Brand.UUID)
+	}
+
+	results, err = brandsDriver.Search("valid", 0, 10)
+	assert.NoError(err)
+	assert.Equal([]string{validChildBrandUuid, validSimpleBrandUuid, validSkeletonBrandUuid}, searchUUIDs(results),
+		"Expected shorter prefix matches first")
+
+	results, err = brandsDriver.Search("valid", 0, 1)
+	assert.NoError(err)
+	assert.Equal([]string{validChildBrandUuid}, searchUUIDs(results))
+
+	results, err = brandsDriver.Search("someWonkyBrand", 0, 10)
+	assert.NoError(err)
+	if assert.Len(results, 1) {
+		assert.Equal(ExactMatch, results[0].Match)
+		assert.Equal("SomeWonkyBrand", results[0].Term, "Expected the alias that matched")
+		assert.Equal(validChildBrandUuid, results[0].Brand.UUID)
+	}
+
+	results, err = brandsDriver.Search("validSimpelBrand", 2, 10)
+	assert.NoError(err)
+	if assert.NotEmpty(results) {
+		assert.Equal(FuzzyMatch, results[0].Match)
+		assert.Equal(2, results[0].Distance)
+		assert.Equal(validSimpleBrandUuid, results[0].Brand.UUID)
+	}
+
+	results, err = brandsDriver.Search("validSimpelBrand", 1, 10)
+	assert.NoError(err)
+	assert.Empty(results)
+}
+
+func testWriteBrandIfVersionMatches(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	status, err := brandsDriver.WriteBrandIf(validSimpleBrand, Precondition{IfNoneMatch: []string{AnyVersion}})
+	assert.NoError(err)
+	assert.Equal(Written, status)
+
+	_, err = brandsDriver.WriteBrandIf(validSimpleBrand, Precondition{IfNoneMatch: []string{AnyVersion}})
+	assert.Equal(PreconditionFailedError{UUID: validSimpleBrandUuid, Version: validSimpleBrand.contentHash(), Exists: true}, err)
+
+	_, version, found, err := brandsDriver.ReadVersion(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(validSimpleBrand.contentHash(), version)
+
+	renamed := validSimpleBrand
+	renamed.PrefLabel = "renamedSimpleBrand"
+	status, err = brandsDriver.WriteBrandIf(renamed, Precondition{IfMatch: []string{version}})
+	assert.NoError(err)
+	assert.Equal(Written, status)
+
+	_, err = brandsDriver.WriteBrandIf(validSimpleBrand, Precondition{IfMatch: []string{version}})
+	assert.Equal(PreconditionFailedError{UUID: validSimpleBrandUuid, Version: renamed.contentHash(), Exists: true}, err,
+		"A write based on a version that has since changed should fail")
+	readBrandAndCompare(renamed, t, db)
+
+	_, err = brandsDriver.WriteBrandIf(validSkeletonBrand, Precondition{IfMatch: []string{AnyVersion}})
+	assert.Equal(PreconditionFailedError{UUID: validSkeletonBrandUuid}, err)
+	assert.False(doesThingExistAtAll(validSkeletonBrandUuid, db, t, assert))
+
+	status, err = brandsDriver.WriteBrandIf(validSimpleBrand, Precondition{IfMatch: []string{AnyVersion}})
+	assert.NoError(err)
+	assert.Equal(Written, status)
+}
+
+func testHistoryRecordsWritesAndDeletes(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.WithTransactionID("tid_create").Write(validSimpleBrand))
+	assert.NoError(brandsDriver.Write(validSimpleBrand), "An unchanged write shouldn't be recorded")
+	renamed := validSimpleBrand
+	renamed.PrefLabel = "renamedSimpleBrand"
+	renamed.Aliases = []string{"SimpleAlias"}
+	assert.NoError(brandsDriver.WithTransactionID("tid_rename").Write(renamed))
+	_, err := brandsDriver.WithTransactionID("tid_delete").DeleteBrand(validSimpleBrandUuid, SoftDelete)
+	assert.NoError(err)
+	_, err = brandsDriver.DeleteBrand(validSimpleBrandUuid, SoftDelete)
+	assert.NoError(err, "Deleting a deleted brand shouldn't be recorded")
+
+	changes, err := brandsDriver.History(validSimpleBrandUuid)
+	assert.NoError(err)
+	if !assert.Len(changes, 3) {
+		return
+	}
+
+	created := changes[0]
+	assert.Equal(1, created.Version)
+	assert.Equal(WriteOperation, created.Operation)
+	assert.Equal("tid_create", created.TransactionID)
+	_, err = time.Parse(time.RFC3339Nano, created.Timestamp)
+	assert.NoError(err)
+	assert.Nil(created.Previous)
+	assert.Equal(validSimpleBrand.PrefLabel, created.Current.PrefLabel)
+	assert.Contains(created.ChangedFields, "prefLabel")
+	assert.Contains(created.ChangedFields, "alternativeIdentifiers")
+
+	updated := changes[1]
+	assert.Equal(2, updated.Version)
+	assert.Equal("tid_rename", updated.TransactionID)
+	assert.Equal([]string{"aliases", "prefLabel"}, updated.ChangedFields)
+	assert.Equal(validSimpleBrand.PrefLabel, updated.Previous.PrefLabel)
+	assert.Equal(renamed.PrefLabel, updated.Current.PrefLabel)
+
+	deleted := changes[2]
+	assert.Equal(3, deleted.Version)
+	assert.Equal(DeleteOperation, deleted.Operation)
+	assert.Equal(SoftDelete, deleted.Mode)
+	assert.Equal("tid_delete", deleted.TransactionID)
+	assert.Equal(renamed.PrefLabel, deleted.Previous.PrefLabel)
+	assert.Nil(deleted.Current)
+
+	changes, err = brandsDriver.History(validSkeletonBrandUuid)
+	assert.NoError(err)
+	assert.Empty(changes)
+}
+
+func testReadAsOfRebuildsEarlierBrand(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+	assert.NoError(brandsDriver.Write(validChildBrand))
+	moved := validChildBrand
+	moved.ParentUUID = ""
+	moved.Aliases = nil
+	moved.AlternativeIdentifiers.TME = []string{"456"}
+	assert.NoError(brandsDriver.Write(moved))
+	_, err := brandsDriver.DeleteBrand(validChildBrandUuid, ConditionalDelete)
+	assert.NoError(err)
+
+	changes, err := brandsDriver.History(validChildBrandUuid)
+	assert.NoError(err)
+	if !assert.Len(changes, 3) {
+		return
+	}
+	times := make([]time.Time, len(changes))
+	for i, change := range changes {
+		times[i], err = time.Parse(time.RFC3339Nano, change.Timestamp)
+		assert.NoError(err)
+	}
+
+	_, found, err := brandsDriver.ReadAsOf(validChildBrandUuid, times[0].Add(-time.Nanosecond))
+	assert.NoError(err)
+	assert.False(found, "The brand hadn't been written yet")
+
+	brand, found, err := brandsDriver.ReadAsOf(validChildBrandUuid, times[0])
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(parentBrandUuid, brand.ParentUUID)
+	assert.Equal(validChildBrand.Aliases, brand.Aliases)
+	assert.Equal(validChildBrand.AlternativeIdentifiers.TME, brand.AlternativeIdentifiers.TME)
+
+	brand, found, err = brandsDriver.ReadAsOf(validChildBrandUuid, times[2].Add(-time.Nanosecond))
+	assert.NoError(err)
+	assert.True(found)
+	assert.Empty(brand.ParentUUID)
+	assert.Empty(brand.Aliases)
+	assert.Equal([]string{"456"}, brand.AlternativeIdentifiers.TME)
+
+	_, found, err = brandsDriver.ReadAsOf(validChildBrandUuid, times[2])
+	assert.NoError(err)
+	assert.False(found, "The brand had been deleted")
+
+	_, found, err = brandsDriver.ReadAsOf(validSkeletonBrandUuid, time.Now())
+	assert.NoError(err)
+	assert.False(found)
+}
+
+func testRollbackRewritesEarlierVersion(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+	assert.NoError(brandsDriver.Write(validChildBrand))
+	badEdit := validChildBrand
+	badEdit.PrefLabel = "badEdit"
+	badEdit.ParentUUID = ""
+	assert.NoError(brandsDriver.Write(badEdit))
+
+	result, err := brandsDriver.WithTransactionID("tid_rollback").Rollback(validChildBrandUuid, 1)
+	assert.NoError(err)
+	assert.Equal(RollbackResult{UUID: validChildBrandUuid, Version: 1, Status: Written}, result)
+	readBrandAndCompare(validChildBrand, t, db)
+
+	changes, err := brandsDriver.History(validChildBrandUuid)
+	assert.NoError(err)
+	if assert.Len(changes, 3) {
+		assert.Equal(RollbackOperation, changes[2].Operation)
+		assert.Equal(1, changes[2].RollbackOf)
+		assert.Equal("tid_rollback", changes[2].TransactionID)
+		assert.Equal([]string{"parentUUID", "prefLabel"}, changes[2].ChangedFields)
+	}
+
+	result, err = brandsDriver.Rollback(validChildBrandUuid, 1)
+	assert.NoError(err)
+	assert.Equal(Unchanged, result.Status, "Rolling back to the brand as it is should change nothing")
+
+	_, err = brandsDriver.DeleteBrand(validChildBrandUuid, SoftDelete)
+	assert.NoError(err)
+	_, err = brandsDriver.Rollback(validChildBrandUuid, 4)
+	assert.IsType(RollbackError{}, err, "A version that deleted the brand can't be rolled back to")
+	_, err = brandsDriver.Rollback(validChildBrandUuid, 5)
+	assert.IsType(RollbackError{}, err)
+
+	_, err = brandsDriver.RollbackAsOf(validChildBrandUuid, time.Now())
+	assert.IsType(RollbackError{}, err, "The brand was deleted as of now")
+	_, err = brandsDriver.RollbackAsOf(validChildBrandUuid, time.Time{})
+	assert.IsType(RollbackError{}, err)
+
+	changes, err = brandsDriver.History(validChildBrandUuid)
+	assert.NoError(err)
+	at, err := time.Parse(time.RFC3339Nano, changes[1].Timestamp)
+	assert.NoError(err)
+	result, err = brandsDriver.RollbackAsOf(validChildBrandUuid, at)
+	assert.NoError(err)
+	assert.Equal(RollbackResult{UUID: validChildBrandUuid, Version: 2, Status: Written}, result)
+	readBrandAndCompare(badEdit, t, db)
+}
+
+func testDiffAgainstStoredBrand(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	brandsDriver := getCypherDriver(db)
+
+	defer cleanDB([]string{validSimpleBrandUuid, validChildBrandUuid}, db, t, assert)
+
+	diff, err := brandsDriver.Diff(validChildBrand)
+	assert.NoError(err)
+	assert.False(diff.Stored)
+	assert.True(diff.Changed)
+	assert.Equal(FieldChange{To: validChildBrand.PrefLabel}, diff.Fields["prefLabel"])
+	assert.Equal([]string{"AnotherAliasForABrand", "SomeWonkyBrand"}, diff.Aliases.Added)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+	assert.NoError(brandsDriver.Write(validChildBrand))
+
+	diff, err = brandsDriver.Diff(validChildBrand)
+	assert.NoError(err)
+	assert.True(diff.Stored)
+	assert.False(diff.Changed)
+	assert.Empty(diff.Fields)
+
+	edited := validChildBrand
+	edited.PrefLabel = "editedChildBrand"
+	edited.ParentUUID = ""
+	edited.Aliases = []string{"SomeWonkyBrand", "NewAlias"}
+	edited.AlternativeIdentifiers.TME = []string{"456"}
+	diff, err = brandsDriver.Diff(edited)
+	assert.NoError(err)
+	assert.True(diff.Changed)
+	assert.Equal(map[string]FieldChange{
+		"prefLabel":  {From: validChildBrand.PrefLabel, To: "editedChildBrand"},
+		"parentUUID": {From: parentBrandUuid},
+	}, diff.Fields)
+	assert.Equal(ListChange{Added: []string{"NewAlias"}, Removed: []string{"AnotherAliasForABrand"}}, diff.Aliases)
+	assert.Equal(ListChange{Added: []string{"456"}, Removed: validChildBrand.AlternativeIdentifiers.TME}, diff.Identifiers["TME"])
+	assert.Equal(ListChange{Added: []string{}, Removed: []string{}}, diff.Identifiers["UPP"])
+	readBrandAndCompare(validChildBrand, t, db)
+
+	edited.PrefLabel = ""
+	_, err = brandsDriver.Diff(edited)
+	assert.IsType(ValidationError{}, err)
+}
+
+func testChangesArePublishedAsEvents(t *testing.T, db neoutils.NeoConnection) {
+	assert := assert.New(t)
+	sink := &recordingSink{}
+	brandsDriver := getCypherDriver(db).WithEventSink(sink).WithTransactionID("tid_events")
+
+	defer cleanDB([]string{validSimpleBrandUuid, validSkeletonBrandUuid}, db, t, assert)
+
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+	edited := validSimpleBrand
+	edited.Strapline = "Keeping it simpler"
+	results := brandsDriver.WriteAll([]Brand{edited, validSkeletonBrand, {PrefLabel: "no uuid"}})
+	assert.Equal(Written, results[0].Status)
+	_, err := brandsDriver.DeleteBrand(validSimpleBrandUuid, ConditionalDelete)
+	assert.NoError(err)
+	_, err = brandsDriver.DeleteBrand(validSimpleBrandUuid, ConditionalDelete)
+	assert.NoError(err)
+
+	// the unchanged write, the invalid brand and the second delete publish nothing
+	events := sink.events
+	if !assert.Len(events, 4) {
+		return
+	}
+	assert.Equal(ChangeEvent{
+		UUID:          validSimpleBrandUuid,
+		Type:          WriteOperation,
+		TransactionID: "tid_events",
+		Timestamp:     events[0].Timestamp,
+		ChangedFields: []string{"_imageUrl", "alternativeIdentifiers", "description", "descriptionXML", "prefLabel", "strapline", "uuid"},
+		Payload:       &validSimpleBrand,
+	}, events[0])
+	assert.Equal([]string{"strapline"}, events[1].ChangedFields)
+	assert.Equal(&edited, events[1].Payload)
+	assert.Equal(validSkeletonBrandUuid, events[2].UUID)
+	assert.Equal(DeleteOperation, events[3].Type)
+	assert.Nil(events[3].Payload)
+	assert.Equal("tid_events", events[3].TransactionID)
+}
+
+func searchUUIDs(results []SearchResult) []string {
+	uuids := []string{}
+	for _, result := range results {
+		uuids = append(uuids, result.Brand.UUID)
+	}
+	return uuids
+}
+
+func brandUUIDs(brands []Brand) []string {
+	uuids := []string{}
+	for _, brand := range brands {
+		uuids = append(uuids, brand.UUID)
+	}
+	return uuids
+}
+
 func writeAnnotation(assert *assert.Assertions, db neoutils.NeoConnection) annotations.Service {
+	if fake, ok := db.(*fakeNeoConnection); ok {
+		fake.annotate(contentUuid, "IS_CLASSIFIED_BY", validSimpleBrandUuid)
+		return nil
+	}
 	annotationsRW := annotations.NewCypherAnnotationsService(db, "v2")
 	assert.NoError(annotationsRW.Initialise())
 	writeJSONToAnnotationsService(annotationsRW, contentUuid, "./fixtures/Annotations-3fc9fe3e-af8c-4f7f-961a-e5065392bb31-v2.json", assert)
 	return annotationsRW
 }
 
+// annotateVersion classifies the content by the concept for one platform version, as the annotations writer
+// would for that version
+func annotateVersion(assert *assert.Assertions, db neoutils.NeoConnection, conceptUUID string, platformVersion string) {
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement: `
+			MATCH (b:Thing {uuid:{concept}})
+			MERGE (c:Thing {uuid:{content}})
+			SET c:Content
+			MERGE (c)-[:IS_CLASSIFIED_BY {platformVersion:{platformVersion}}]->(b)`,
+		Parameters: neoism.Props{
+			"concept":         conceptUUID,
+			"content":         contentUuid,
+			"platformVersion": platformVersion,
+		},
+	}}))
+}
+
+// annotationVersions returns the platform versions the content is classified by the concept for
+func annotationVersions(assert *assert.Assertions, db neoutils.NeoConnection, conceptUUID string) []string {
+	results := []struct {
+		PlatformVersion string `json:"platformVersion"`
+	}{}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement: `
+			MATCH (:Thing {uuid:{content}})-[r:IS_CLASSIFIED_BY]->(:Thing {uuid:{concept}})
+			RETURN r.platformVersion AS platformVersion
+			ORDER BY platformVersion`,
+		Parameters: neoism.Props{
+			"concept": conceptUUID,
+			"content": contentUuid,
+		},
+		Result: &results,
+	}}))
+
+	var versions []string
+	for _, result := range results {
+		versions = append(versions, result.PlatformVersion)
+	}
+	return versions
+}
+
 func writeContent(assert *assert.Assertions, db neoutils.NeoConnection) baseftrwapp.Service {
+	if fake, ok := db.(*fakeNeoConnection); ok {
+		fake.graph.mergeNode("Thing", "uuid", contentUuid).addLabels("Content")
+		return nil
+	}
 	contentRW := content.NewCypherContentService(db)
 	assert.NoError(contentRW.Initialise())
 	writeJSONToService(contentRW, "./fixtures/Content-3fc9fe3e-af8c-4f7f-961a-e5065392bb31.json", assert)
@@ -284,46 +1408,191 @@ func readBrandAndCompare(expected Brand, t *testing.T, db neoutils.NeoConnection
 }
 
 func getCypherDriver(db neoutils.NeoConnection) service {
-	cr := NewCypherBrandsService(db)
+	cr := NewCypherBrandsService(db, testBatchSize, PlaceholderParents)
 	cr.Initialise()
 	return cr
 }
 
-func getDatabaseConnectionAndCheckClean(t *testing.T, assert *assert.Assertions) neoutils.NeoConnection {
-	db := getDatabaseConnection(assert)
-	checkDbClean([]string{
-		validSkeletonBrandUuid,
-		validSimpleBrandUuid,
-		validChildBrandUuid,
-		specialCharBrandUuid,
-		contentUuid,
-		parentBrandUuid}, db, t)
-	return db
+var allTestUuids = []string{
+	validSkeletonBrandUuid,
+	validSimpleBrandUuid,
+	validChildBrandUuid,
+	specialCharBrandUuid,
+	contentUuid,
+	parentBrandUuid,
+}
+
+// racingNeoConnection runs race just before the first batch that guards a brand's version, as a concurrent
+// writer could
+type racingNeoConnection struct {
+	*fakeNeoConnection
+	race func()
+}
+
+func (c *racingNeoConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	if c.race != nil && strings.Contains(queries[0].Statement, "WHERE versions <> {versions}") {
+		race := c.race
+		c.race = nil
+		race()
+	}
+	return c.fakeNeoConnection.CypherBatch(queries)
+}
+
+// failingNeoConnection fails every batch from the first that guards a brand's version on, numbering the
+// failures, as a Neo4j that goes down mid-write would
+type failingNeoConnection struct {
+	*fakeNeoConnection
+	failures int
 }
 
-func getDatabaseConnection(assert *assert.Assertions) neoutils.NeoConnection {
-	url := os.Getenv("NEO4J_TEST_URL")
-	if url == "" {
-		url = "http://localhost:7474/db/data"
+func (c *failingNeoConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	if c.failures > 0 || strings.Contains(queries[0].Statement, "WHERE versions <> {versions}") {
+		c.failures++
+		return fmt.Errorf("Neo4j is unavailable, failure %d", c.failures)
 	}
+	return c.fakeNeoConnection.CypherBatch(queries)
+}
+
+func TestWriteBrandIfReturnsBatchErrorWhenChecksFailToo(t *testing.T) {
+	assert := assert.New(t)
+	fake := newFakeNeoConnection()
+	assert.NoError(getCypherDriver(fake).Write(validSimpleBrand))
+	assert.NoError(getCypherDriver(fake).Write(validChildBrand))
+
+	updated := validChildBrand
+	updated.Strapline = "Updated strapline"
+	_, err := getCypherDriver(&failingNeoConnection{fakeNeoConnection: fake}).WriteBrandIf(updated, Precondition{IfMatch: []string{AnyVersion}})
+	assert.EqualError(err, "Neo4j is unavailable, failure 1")
+	readBrandAndCompare(validChildBrand, t, fake)
+}
+
+func TestPublishFailureDoesNotFailTheChange(t *testing.T) {
+	assert := assert.New(t)
+	db := newFakeNeoConnection()
+	brandsDriver := getCypherDriver(db).WithEventSink(&recordingSink{err: errors.New("sink unavailable")})
+
+	failed := failedEvents.Count()
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+	assert.Equal(failed+1, failedEvents.Count())
+	readBrandAndCompare(validSimpleBrand, t, db)
+}
+
+func TestWriteBrandIfLosingRaceFailsPrecondition(t *testing.T) {
+	assert := assert.New(t)
+	fake := newFakeNeoConnection()
+	assert.NoError(getCypherDriver(fake).Write(validSimpleBrand))
+
+	renamed := validSimpleBrand
+	renamed.PrefLabel = "renamedSimpleBrand"
+	conn := &racingNeoConnection{fakeNeoConnection: fake, race: func() {
+		assert.NoError(getCypherDriver(fake).Write(renamed))
+	}}
+
+	updated := validSimpleBrand
+	updated.Strapline = "Updated strapline"
+	_, err := getCypherDriver(conn).WriteBrandIf(updated, Precondition{IfMatch: []string{validSimpleBrand.contentHash()}})
+	assert.Equal(PreconditionFailedError{UUID: validSimpleBrandUuid, Version: renamed.contentHash(), Exists: true}, err)
+	readBrandAndCompare(renamed, t, fake)
+}
+
+func TestRollbackFindsVersionByNumber(t *testing.T) {
+	assert := assert.New(t)
+	fake := newFakeNeoConnection()
+	brandsDriver := getCypherDriver(fake)
+
+	edited := validSimpleBrand
+	edited.Strapline = "Edited strapline"
+	for _, brand := range []Brand{validSimpleBrand, edited, validSimpleBrand} {
+		assert.NoError(brandsDriver.Write(brand))
+	}
+	// with its first change gone, the history's second entry is version 3
+	for _, c := range fake.graph.nodesLabelled("BrandChange") {
+		if c.props["version"] == float64(1) {
+			assert.NoError(fake.graph.deleteNode(c))
+		}
+	}
+
+	result, err := brandsDriver.Rollback(validSimpleBrandUuid, 2)
+	assert.NoError(err)
+	assert.Equal(RollbackResult{UUID: validSimpleBrandUuid, Version: 2, Status: Written}, result)
+	readBrandAndCompare(edited, t, fake)
+	_, err = brandsDriver.Rollback(validSimpleBrandUuid, 1)
+	assert.IsType(RollbackError{}, err)
+}
+
+func TestChangeVersionsAreUnique(t *testing.T) {
+	assert := assert.New(t)
+	fake := newFakeNeoConnection()
+	brandsDriver := getCypherDriver(fake)
+	assert.NoError(brandsDriver.Initialise())
+	assert.NoError(brandsDriver.Write(validSimpleBrand))
+
+	// a concurrent transaction has committed version 2, which this one couldn't see when it numbered its change
+	fake.graph.createNode("BrandChange").setProps(map[string]interface{}{"key": validSimpleBrandUuid + "/2"})
+
+	edited := validSimpleBrand
+	edited.Strapline = "Edited strapline"
+	assert.Error(brandsDriver.Write(edited))
+	readBrandAndCompare(validSimpleBrand, t, fake)
+	changes, err := brandsDriver.History(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.Len(changes, 1)
+}
 
+type testConnection struct {
+	name    string
+	connect func(assert *assert.Assertions) neoutils.NeoConnection
+}
+
+// testConnections always includes the in-memory fake, and a real Neo4j when NEO4J_TEST_URL is set
+func testConnections() []testConnection {
+	conns := []testConnection{{
+		name: "fake",
+		connect: func(*assert.Assertions) neoutils.NeoConnection {
+			return newFakeNeoConnection()
+		},
+	}}
+
+	if url := os.Getenv("NEO4J_TEST_URL"); url != "" {
+		conns = append(conns, testConnection{
+			name: "neo4j",
+			connect: func(assert *assert.Assertions) neoutils.NeoConnection {
+				return getDatabaseConnection(url, assert)
+			},
+		})
+	}
+	return conns
+}
+
+func getDatabaseConnection(url string, assert *assert.Assertions) neoutils.NeoConnection {
 	conf := neoutils.DefaultConnectionConfig()
 	conf.Transactional = false
+	// as in main, so one test's rejected write can't fail another's
+	conf.BatchSize = 0
 	db, err := neoutils.Connect(url, conf)
 	assert.NoError(err, "Failed to connect to Neo4j")
 	return db
 }
 
 func cleanDB(uuidsToClean []string, db neoutils.NeoConnection, t *testing.T, assert *assert.Assertions) {
-	qs := make([]*neoism.CypherQuery, len(uuidsToClean))
-	for i, uuid := range uuidsToClean {
-		qs[i] = &neoism.CypherQuery{
-			Statement: fmt.Sprintf(`
-			MATCH (a:Thing {uuid: "%s"})
-			OPTIONAL MATCH (a)-[rel]-(s)
-			OPTIONAL MATCH (p)-[rel2]-(id)
-			DELETE rel2, id, rel, s
-			DETACH DELETE a`, uuid)}
+	var qs []*neoism.CypherQuery
+	for _, uuid := range uuidsToClean {
+		qs = append(qs, &neoism.CypherQuery{
+			Statement: `
+			MATCH (a:Thing {uuid: {uuid}})
+			OPTIONAL MATCH (a)<-[:IDENTIFIES]-(i:Identifier)
+			DETACH DELETE i, a`,
+			Parameters: neoism.Props{
+				"uuid": uuid,
+			},
+		}, &neoism.CypherQuery{
+			Statement: `
+			MATCH (c:BrandChange {uuid: {uuid}})
+			DELETE c`,
+			Parameters: neoism.Props{
+				"uuid": uuid,
+			},
+		})
 	}
 
 	err := db.CypherBatch(qs)
@@ -353,12 +1622,12 @@ func checkDbClean(uuidsCleaned []string, db neoutils.NeoConnection, t *testing.T
 
 func doesThingExistAtAll(uuid string, db neoutils.NeoConnection, t *testing.T, assert *assert.Assertions) bool {
 	result := []struct {
-		Uuid string `json:"thing.uuid"`
+		Uuid string `json:"uuid"`
 	}{}
 
 	checkGraph := neoism.CypherQuery{
 		Statement: `
-			MATCH (a:Thing {uuid: "%s"}) return a.uuid
+			MATCH (a:Thing {uuid: {uuid}}) RETURN a.uuid AS uuid
 		`,
 		Parameters: neoism.Props{
 			"uuid": uuid,
@@ -379,14 +1648,14 @@ func doesThingExistAtAll(uuid string, db neoutils.NeoConnection, t *testing.T, a
 func doesThingExistWithIdentifiers(uuid string, db neoutils.NeoConnection, t *testing.T, assert *assert.Assertions) bool {
 
 	result := []struct {
-		uuid string `json:"thing.uuid"`
+		Uuid string `json:"uuid"`
 	}{}
 
 	checkGraph := neoism.CypherQuery{
 		Statement: `
-			MATCH (a:Thing {uuid: "%s"})-[:IDENTIFIES]-(:Identifier)
-			WITH collect(distinct a.uuid) as uuid
-			RETURN uuid
+			MATCH (a:Thing {uuid: {uuid}})-[:IDENTIFIES]-(:Identifier)
+			WITH DISTINCT a
+			RETURN a.uuid AS uuid
 		`,
 		Parameters: neoism.Props{
 			"uuid": uuid,
@@ -401,6 +1670,5 @@ func doesThingExistWithIdentifiers(uuid string, db neoutils.NeoConnection, t *te
 		return false
 	}
 
-	fmt.Printf("Result3: %v", len(result))
 	return true
 }
@@ -0,0 +1,128 @@
+package brands
+
+import (
+	"github.com/jmcvetta/neoism"
+)
+
+// WriteStatus describes what happened to a single brand in a bulk write
+type WriteStatus string
+
+const (
+	// Written means the brand was stored
+	Written WriteStatus = "written"
+	// Unchanged means the stored brand already matched, so nothing was written
+	Unchanged WriteStatus = "unchanged"
+	// Rejected means the brand was invalid and nothing was sent to Neo4j for it
+	Rejected WriteStatus = "rejected"
+	// Failed means Neo4j refused the write
+	Failed WriteStatus = "failed"
+)
+
+// WriteResult is the outcome of writing one brand as part of a bulk write
+type WriteResult struct {
+	UUID   string      `json:"uuid"`
+	Status WriteStatus `json:"status"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// WriteAll writes the brands in as few CypherBatch calls as the batch size allows, returning a result
+// for each brand in the order given. Brands whose stored content already matches are skipped. If a batch
+// fails, its brands are retried one at a time so that a single bad brand doesn't take the rest of the
+// batch down with it. A brand is checked against what was stored before the write, so a uuid given more
+// than once is rejected after its first appearance. A uuid merged into another brand is rejected too.
+func (s service) WriteAll(brands []Brand) []WriteResult {
+	results := make([]WriteResult, len(brands))
+	seen := map[string]bool{}
+
+	var uuids []string
+	for _, brand := range brands {
+		uuids = append(uuids, brand.UUID)
+	}
+	stored, err := s.storedBrands(uuids)
+	var mergeTargets map[string]string
+	if err == nil {
+		var missing []string
+		for _, uuid := range uuids {
+			if _, exists := stored[uuid]; !exists {
+				missing = append(missing, uuid)
+			}
+		}
+		mergeTargets, err = s.mergeTargets(missing)
+	}
+	if err != nil {
+		for i, brand := range brands {
+			results[i] = WriteResult{UUID: brand.UUID, Status: Failed, Reason: err.Error()}
+		}
+		return results
+	}
+
+	var pending []int
+	var queries []*neoism.CypherQuery
+	var changes []BrandChange
+
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := s.conn.CypherBatch(queries); err != nil {
+			for _, i := range pending {
+				results[i] = s.writeOne(brands[i])
+			}
+		} else {
+			for _, i := range pending {
+				results[i] = WriteResult{UUID: brands[i].UUID, Status: Written}
+			}
+			writtenBrands.Inc(int64(len(pending)))
+			s.publish(changes...)
+		}
+		pending, queries, changes = nil, nil, nil
+	}
+
+	for i, brand := range brands {
+		if err := brand.Validate(); err != nil {
+			results[i] = WriteResult{UUID: brand.UUID, Status: Rejected, Reason: err.Error()}
+			continue
+		}
+
+		if seen[brand.UUID] {
+			results[i] = WriteResult{UUID: brand.UUID, Status: Rejected, Reason: "Brand appears more than once in the bulk write"}
+			continue
+		}
+		seen[brand.UUID] = true
+
+		if target, merged := mergeTargets[brand.UUID]; merged {
+			results[i] = WriteResult{UUID: brand.UUID, Status: Rejected, Reason: MergedBrandError{UUID: brand.UUID, Target: target}.Error()}
+			continue
+		}
+
+		previous, exists := stored[brand.UUID]
+		if exists && previous.Version == brand.contentHash() {
+			results[i] = WriteResult{UUID: brand.UUID, Status: Unchanged}
+			unchangedBrands.Inc(1)
+			continue
+		}
+
+		brandQueries, change := s.writeQueries(brand, previous.brand(exists))
+		if s.batchSize > 0 && len(queries)+len(brandQueries) > s.batchSize {
+			flush()
+		}
+		pending = append(pending, i)
+		queries = append(queries, brandQueries...)
+		changes = append(changes, change)
+	}
+	flush()
+
+	return results
+}
+
+func (s service) writeOne(brand Brand) WriteResult {
+	status, err := s.WriteBrand(brand)
+	switch err.(type) {
+	case nil:
+	case ValidationError, IdentifierConflictError, CycleError, MissingParentError, MergedBrandError:
+		return WriteResult{UUID: brand.UUID, Status: Rejected, Reason: err.Error()}
+	default:
+		return WriteResult{UUID: brand.UUID, Status: Failed, Reason: err.Error()}
+	}
+	return WriteResult{UUID: brand.UUID, Status: status}
+}
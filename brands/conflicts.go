@@ -0,0 +1,93 @@
+package brands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// IdentifierConflict is an alternative identifier a brand asks for that already belongs to another concept.
+// OwnerUUID is empty when the identifier exists but no longer identifies anything.
+type IdentifierConflict struct {
+	Authority string `json:"authority"`
+	Value     string `json:"value"`
+	OwnerUUID string `json:"ownerUUID"`
+}
+
+// IdentifierConflictError is returned when a brand can't be written because some of its alternative
+// identifiers are already claimed
+type IdentifierConflictError struct {
+	UUID      string
+	Conflicts []IdentifierConflict
+}
+
+func (e IdentifierConflictError) Error() string {
+	var claims []string
+	for _, c := range e.Conflicts {
+		if c.OwnerUUID == "" {
+			claims = append(claims, fmt.Sprintf("%s identifier %s exists but identifies nothing", c.Authority, c.Value))
+		} else {
+			claims = append(claims, fmt.Sprintf("%s identifier %s belongs to %s", c.Authority, c.Value, c.OwnerUUID))
+		}
+	}
+	return fmt.Sprintf("Brand %s has identifiers claimed elsewhere: %s", e.UUID, strings.Join(claims, "; "))
+}
+
+// identifierAuthorities names the authority behind each identifier label
+var identifierAuthorities = map[string]string{
+	tmeIdentifierLabel: "TME",
+	uppIdentifierLabel: "UPP",
+}
+
+// checkIdentifierConflicts returns an IdentifierConflictError if any of the brand's alternative identifiers
+// already exist for something other than the brand, which would break the identifier uniqueness constraints
+func (s service) checkIdentifierConflicts(brand Brand) error {
+	results := []struct {
+		Value  string   `json:"value"`
+		Labels []string `json:"labels"`
+		Owner  string   `json:"owner"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (i:Identifier)
+			WHERE (i:TMEIdentifier AND i.value IN {tme}) OR (i:UPPIdentifier AND i.value IN {upp})
+			OPTIONAL MATCH (i)-[:IDENTIFIES]->(t:Thing)
+			WITH i, t
+			WHERE t IS NULL OR t.uuid <> {uuid}
+			RETURN i.value AS value, labels(i) AS labels, t.uuid AS owner
+			ORDER BY value`,
+		Parameters: neoism.Props{
+			"uuid": brand.UUID,
+			"tme":  nonNil(brand.AlternativeIdentifiers.TME),
+			"upp":  nonNil(brand.AlternativeIdentifiers.UUIDS),
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	conflictErr := IdentifierConflictError{UUID: brand.UUID}
+	for _, result := range results {
+		conflict := IdentifierConflict{Value: result.Value, OwnerUUID: result.Owner}
+		for _, label := range result.Labels {
+			if authority, ok := identifierAuthorities[label]; ok {
+				conflict.Authority = authority
+			}
+		}
+		conflictErr.Conflicts = append(conflictErr.Conflicts, conflict)
+	}
+	return conflictErr
+}
+
+// nonNil makes sure an empty list goes to Neo4j as [] rather than null
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,189 @@
+package brands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+)
+
+var (
+	consumedBrands   = metrics.GetOrRegisterCounter("brands.consumer.written", metrics.DefaultRegistry)
+	deadLetterBrands = metrics.GetOrRegisterCounter("brands.consumer.deadLettered", metrics.DefaultRegistry)
+)
+
+// ConsumerConfig says where a Consumer reads brands from and parks the ones it can't write
+type ConsumerConfig struct {
+	Brokers         []string
+	Group           string
+	Topic           string
+	DeadLetterTopic string
+	// RetryInterval is how long to wait before trying a write again when Neo4j is unavailable
+	RetryInterval time.Duration
+	// MaxAttempts is how many times a write is tried before its message is parked
+	MaxAttempts int
+}
+
+// Consumer writes the brands in the JSON messages of a Kafka topic, as PUT does, then commits their offsets.
+// A message that can never be written, because it isn't a brand or the brand is rejected, is parked on the
+// dead letter topic and its offset committed. Other failures are retried, so nothing is committed until the
+// brand has been written or, once the attempts run out, parked as well.
+type Consumer struct {
+	service     service
+	deadLetters sarama.SyncProducer
+	config      ConsumerConfig
+}
+
+// NewConsumer returns a consumer writing through the service and parking messages with the producer. The
+// retry interval defaults to 5 seconds, and the attempts to 10.
+func NewConsumer(s service, deadLetters sarama.SyncProducer, config ConsumerConfig) *Consumer {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 10
+	}
+	return &Consumer{service: s, deadLetters: deadLetters, config: config}
+}
+
+// ConsumeBrands joins the consumer group and writes the brands on the topic until the context is done
+func ConsumeBrands(ctx context.Context, s service, config ConsumerConfig) error {
+	kafkaConfig := producerConfig()
+	kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	kafkaConfig.Consumer.Offsets.AutoCommit.Enable = false
+
+	deadLetters, err := sarama.NewSyncProducer(config.Brokers, kafkaConfig)
+	if err != nil {
+		return err
+	}
+	defer deadLetters.Close()
+
+	group, err := sarama.NewConsumerGroup(config.Brokers, config.Group, kafkaConfig)
+	if err != nil {
+		return err
+	}
+	defer group.Close()
+
+	consumer := NewConsumer(s, deadLetters, config)
+	for ctx.Err() == nil {
+		// Consume returns whenever the group rebalances, and is called again to rejoin it
+		if err := group.Consume(ctx, []string{config.Topic}, consumer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Setup is called as the consumer joins a group session
+func (c *Consumer) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is called once the session's claims have all been consumed
+func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim handles the claim's messages in order, committing the offset of each once it is handled
+func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for message := range claim.Messages() {
+		if err := c.handle(session.Context(), message); err != nil {
+			// the offset isn't committed, so the message is consumed again when the group next rebalances
+			return err
+		}
+		session.MarkMessage(message, "")
+		session.Commit()
+	}
+	return nil
+}
+
+// handle writes the message's brand, or parks the message if it can't be written or the attempts to write it
+// run out. It only returns an error if it couldn't do either.
+func (c *Consumer) handle(ctx context.Context, message *sarama.ConsumerMessage) error {
+	thing, uuid, err := c.service.DecodeJSON(json.NewDecoder(bytes.NewReader(message.Value)))
+	if err != nil {
+		return c.park(message, err)
+	}
+	writer := c.service.WithTransactionID(transactionIDHeader(message))
+
+	for attempt := 1; ; attempt++ {
+		err = writer.Write(thing)
+		if err == nil {
+			consumedBrands.Inc(1)
+			return nil
+		}
+		if rejected(err) {
+			return c.park(message, err)
+		}
+		if attempt >= c.config.MaxAttempts {
+			return c.park(message, fmt.Errorf("Gave up after %d attempts: %s", attempt, err))
+		}
+
+		log.Errorf("Failed to write brand %s from offset %d of %s/%d on attempt %d, retrying in %s, err=%s",
+			uuid, message.Offset, message.Topic, message.Partition, attempt, c.config.RetryInterval, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.config.RetryInterval):
+		}
+	}
+}
+
+// rejected says whether the error is the brand's fault, so writing it again would fail the same way
+func rejected(err error) bool {
+	switch err.(type) {
+	case ValidationError, IdentifierConflictError, CycleError, MissingParentError, PreconditionFailedError, MergedBrandError:
+		return true
+	}
+	return false
+}
+
+// park produces the message to the dead letter topic, with headers saying where it came from and why it
+// couldn't be written
+func (c *Consumer) park(message *sarama.ConsumerMessage, reason error) error {
+	headers := []sarama.RecordHeader{
+		{Key: []byte("X-Error"), Value: []byte(reason.Error())},
+		{Key: []byte("X-Original-Topic"), Value: []byte(message.Topic)},
+		{Key: []byte("X-Original-Partition"), Value: []byte(strconv.Itoa(int(message.Partition)))},
+		{Key: []byte("X-Original-Offset"), Value: []byte(strconv.FormatInt(message.Offset, 10))},
+	}
+	for _, header := range message.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+
+	parked := &sarama.ProducerMessage{
+		Topic:   c.config.DeadLetterTopic,
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	}
+	if message.Key != nil {
+		parked.Key = sarama.ByteEncoder(message.Key)
+	}
+	if _, _, err := c.deadLetters.SendMessage(parked); err != nil {
+		return fmt.Errorf("Couldn't park offset %d of %s/%d on %s: %s",
+			message.Offset, message.Topic, message.Partition, c.config.DeadLetterTopic, err)
+	}
+	deadLetterBrands.Inc(1)
+	log.Warnf("Parked offset %d of %s/%d on %s, err=%s", message.Offset, message.Topic, message.Partition, c.config.DeadLetterTopic, reason)
+	return nil
+}
+
+// transactionIDHeader returns the message's X-Request-Id header, which is recorded against the brand's change,
+// or a generated transaction id if it has none
+func transactionIDHeader(message *sarama.ConsumerMessage) string {
+	for _, header := range message.Headers {
+		if header != nil && string(header.Key) == transactionidutils.TransactionIDHeader && len(header.Value) > 0 {
+			return string(header.Value)
+		}
+	}
+	return transactionidutils.NewTransactionID()
+}
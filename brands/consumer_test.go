@@ -0,0 +1,214 @@
+//go:build !jenkins
+// +build !jenkins
+
+package brands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testBrandsTopic     = "Brands"
+	testDeadLetterTopic = "BrandsDeadLetter"
+)
+
+// testSession is a consumer group session that records the offsets committed
+type testSession struct {
+	ctx       context.Context
+	marked    []int64
+	committed []int64
+}
+
+func (s *testSession) Claims() map[string][]int32 { return map[string][]int32{testBrandsTopic: {0}} }
+func (s *testSession) MemberID() string           { return "test" }
+func (s *testSession) GenerationID() int32        { return 1 }
+func (s *testSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.marked = append(s.marked, offset)
+}
+func (s *testSession) Commit() {
+	s.committed = append(s.committed, s.marked...)
+	s.marked = nil
+}
+func (s *testSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+func (s *testSession) MarkMessage(message *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(message.Topic, message.Partition, message.Offset, metadata)
+}
+func (s *testSession) Context() context.Context { return s.ctx }
+
+// testClaim hands out the messages given, one after another
+type testClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func newTestClaim(values ...string) testClaim {
+	claim := testClaim{messages: make(chan *sarama.ConsumerMessage, len(values))}
+	for i, value := range values {
+		claim.messages <- &sarama.ConsumerMessage{
+			Topic:     testBrandsTopic,
+			Offset:    int64(i),
+			Key:       []byte("key"),
+			Value:     []byte(value),
+			Headers:   []*sarama.RecordHeader{{Key: []byte("X-Request-Id"), Value: []byte("tid_consumer")}},
+			Timestamp: time.Now(),
+		}
+	}
+	close(claim.messages)
+	return claim
+}
+
+func (c testClaim) Topic() string                            { return testBrandsTopic }
+func (c testClaim) Partition() int32                         { return 0 }
+func (c testClaim) InitialOffset() int64                     { return 0 }
+func (c testClaim) HighWaterMarkOffset() int64               { return int64(len(c.messages)) }
+func (c testClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// flakyNeoConnection fails the first batches sent to it, as a Neo4j that is briefly down does
+type flakyNeoConnection struct {
+	*fakeNeoConnection
+	failures int
+}
+
+func (c *flakyNeoConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("Neo4j is unavailable")
+	}
+	return c.fakeNeoConnection.CypherBatch(queries)
+}
+
+func newTestConsumer(t *testing.T, s service) (*Consumer, *mocks.SyncProducer) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	deadLetters := mocks.NewSyncProducer(t, config)
+	consumer := NewConsumer(s, deadLetters, ConsumerConfig{
+		Topic:           testBrandsTopic,
+		DeadLetterTopic: testDeadLetterTopic,
+		RetryInterval:   time.Millisecond,
+		MaxAttempts:     5,
+	})
+	return consumer, deadLetters
+}
+
+func brandMessage(t *testing.T, brand Brand) string {
+	data, err := json.Marshal(brand)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestConsumerWritesBrandsAndCommits(t *testing.T) {
+	assert := assert.New(t)
+	db := newFakeNeoConnection()
+	brandsDriver := getCypherDriver(db)
+	consumer, deadLetters := newTestConsumer(t, brandsDriver)
+	defer deadLetters.Close()
+
+	session := &testSession{ctx: context.Background()}
+	claim := newTestClaim(brandMessage(t, validSimpleBrand), brandMessage(t, validChildBrand), brandMessage(t, validSimpleBrand))
+	assert.NoError(consumer.ConsumeClaim(session, claim))
+
+	assert.Equal([]int64{0, 1, 2}, session.committed)
+	readBrandAndCompare(validSimpleBrand, t, db)
+	readBrandAndCompare(validChildBrand, t, db)
+
+	changes, err := brandsDriver.History(validSimpleBrandUuid)
+	assert.NoError(err)
+	if assert.Len(changes, 1) {
+		assert.Equal("tid_consumer", changes[0].TransactionID)
+	}
+}
+
+func TestConsumerParksMessagesThatCantBeWritten(t *testing.T) {
+	assert := assert.New(t)
+	db := newFakeNeoConnection()
+	consumer, deadLetters := newTestConsumer(t, getCypherDriver(db))
+	defer deadLetters.Close()
+
+	invalid := validSimpleBrand
+	invalid.PrefLabel = ""
+	parked := []string{"not a brand", brandMessage(t, invalid)}
+	for i, value := range parked {
+		offset, value := i, value
+		deadLetters.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(message *sarama.ProducerMessage) error {
+			assert.Equal(testDeadLetterTopic, message.Topic)
+			assert.Equal(sarama.ByteEncoder("key"), message.Key)
+			assert.Equal(sarama.ByteEncoder(value), message.Value)
+			headers := map[string]string{}
+			for _, header := range message.Headers {
+				headers[string(header.Key)] = string(header.Value)
+			}
+			assert.NotEmpty(headers["X-Error"])
+			assert.Equal(testBrandsTopic, headers["X-Original-Topic"])
+			assert.Equal("0", headers["X-Original-Partition"])
+			assert.Equal(strconv.Itoa(offset), headers["X-Original-Offset"])
+			assert.Equal("tid_consumer", headers["X-Request-Id"])
+			return nil
+		})
+	}
+
+	session := &testSession{ctx: context.Background()}
+	claim := newTestClaim(parked[0], parked[1], brandMessage(t, validSimpleBrand))
+	assert.NoError(consumer.ConsumeClaim(session, claim))
+
+	assert.Equal([]int64{0, 1, 2}, session.committed)
+	readBrandAndCompare(validSimpleBrand, t, db)
+}
+
+func TestConsumerRetriesWhenNeo4jIsUnavailable(t *testing.T) {
+	assert := assert.New(t)
+	db := &flakyNeoConnection{fakeNeoConnection: newFakeNeoConnection(), failures: 3}
+	consumer, deadLetters := newTestConsumer(t, NewCypherBrandsService(db, testBatchSize, PlaceholderParents))
+	defer deadLetters.Close()
+
+	session := &testSession{ctx: context.Background()}
+	assert.NoError(consumer.ConsumeClaim(session, newTestClaim(brandMessage(t, validSimpleBrand))))
+
+	assert.Equal([]int64{0}, session.committed)
+	readBrandAndCompare(validSimpleBrand, t, db.fakeNeoConnection)
+}
+
+func TestConsumerDoesNotCommitUnwrittenMessages(t *testing.T) {
+	assert := assert.New(t)
+	consumer, deadLetters := newTestConsumer(t, NewCypherBrandsService(unavailableNeoConnection{newFakeNeoConnection()}, testBatchSize, PlaceholderParents))
+	defer deadLetters.Close()
+	consumer.config.RetryInterval = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	session := &testSession{ctx: ctx}
+	assert.Error(consumer.ConsumeClaim(session, newTestClaim(brandMessage(t, validSimpleBrand))))
+	assert.Empty(session.committed)
+
+	deadLetters.ExpectSendMessageAndFail(errors.New("broker unavailable"))
+	session = &testSession{ctx: context.Background()}
+	assert.Error(consumer.ConsumeClaim(session, newTestClaim("not a brand", brandMessage(t, validSimpleBrand))))
+	assert.Empty(session.committed)
+}
+
+func TestConsumerParksMessagesOnceAttemptsRunOut(t *testing.T) {
+	assert := assert.New(t)
+	consumer, deadLetters := newTestConsumer(t, NewCypherBrandsService(unavailableNeoConnection{newFakeNeoConnection()}, testBatchSize, PlaceholderParents))
+	defer deadLetters.Close()
+
+	deadLetters.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(message *sarama.ProducerMessage) error {
+		for _, header := range message.Headers {
+			if string(header.Key) == "X-Error" {
+				assert.Contains(string(header.Value), "Gave up after 5 attempts")
+			}
+		}
+		return nil
+	})
+
+	session := &testSession{ctx: context.Background()}
+	assert.NoError(consumer.ConsumeClaim(session, newTestClaim(brandMessage(t, validSimpleBrand))))
+	assert.Equal([]int64{0}, session.committed)
+}
@@ -0,0 +1,124 @@
+package brands
+
+import (
+	"fmt"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// DeleteMode decides how much of a brand a delete removes
+type DeleteMode string
+
+const (
+	// SoftDelete swaps the brand labels for a DeletedBrand tombstone, keeping the node, its properties,
+	// identifiers and relationships. The brand is no longer read, listed or counted, and writing it again
+	// restores it.
+	SoftDelete DeleteMode = "soft"
+	// ConditionalDelete removes the brand labels and properties, and deletes the node only if nothing else
+	// is related to it
+	ConditionalDelete DeleteMode = "conditional"
+	// ForcedDelete deletes the node, its identifiers and every relationship it has, whatever they are. Only
+	// brands and soft deleted brands are force deleted, never other things sharing the uuid space.
+	ForcedDelete DeleteMode = "forced"
+)
+
+// deletedBrandLabel marks a soft deleted brand
+const deletedBrandLabel = "DeletedBrand"
+
+// ParseDeleteMode returns the named mode, with an empty name meaning ConditionalDelete
+func ParseDeleteMode(name string) (DeleteMode, error) {
+	switch mode := DeleteMode(name); mode {
+	case "":
+		return ConditionalDelete, nil
+	case SoftDelete, ConditionalDelete, ForcedDelete:
+		return mode, nil
+	}
+	return "", fmt.Errorf("Unknown delete mode %q, it must be one of %s, %s or %s",
+		name, SoftDelete, ConditionalDelete, ForcedDelete)
+}
+
+// DroppedRelationship is a relationship a forced delete removed along with the brand
+type DroppedRelationship struct {
+	Type     string   `json:"type"`
+	Outgoing bool     `json:"outgoing"`
+	UUID     string   `json:"uuid"`
+	Labels   []string `json:"labels"`
+}
+
+func (s service) softDelete(uuid string, history []*neoism.CypherQuery) (DeleteOutcome, error) {
+	outcome := DeleteOutcome{UUID: uuid, Mode: SoftDelete}
+
+	results := []struct {
+		UUID string `json:"uuid"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (n:Brand {uuid: {uuid}})
+			REMOVE n:Brand
+			REMOVE n:Concept
+			REMOVE n:Classification
+			SET n:DeletedBrand
+			RETURN n.uuid AS uuid`,
+		Parameters: neoism.Props{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch(append([]*neoism.CypherQuery{query}, history...)); err != nil {
+		return outcome, err
+	}
+
+	outcome.LabelsRemoved = len(results) > 0
+	return outcome, nil
+}
+
+func (s service) forcedDelete(uuid string, history []*neoism.CypherQuery) (DeleteOutcome, error) {
+	outcome := DeleteOutcome{UUID: uuid, Mode: ForcedDelete}
+
+	dropped := []DroppedRelationship{}
+	findRelationships := &neoism.CypherQuery{
+		Statement: `
+			MATCH (thing:Thing {uuid: {uuid}})-[r]-(x:Thing)
+			WHERE (thing:Brand OR thing:DeletedBrand)
+				AND NOT (type(r) = 'HAS_PARENT' AND startNode(r) = thing)
+			RETURN type(r) AS type, startNode(r) = thing AS outgoing, x.uuid AS uuid, labels(x) AS labels
+			ORDER BY uuid, type`,
+		Parameters: neoism.Props{
+			"uuid": uuid,
+		},
+		Result: &dropped,
+	}
+
+	removed := []struct {
+		LabelsRemoved      int `json:"labelsRemoved"`
+		IdentifiersRemoved int `json:"identifiersRemoved"`
+	}{}
+	deleteNode := &neoism.CypherQuery{
+		Statement: `
+			MATCH (thing:Thing {uuid: {uuid}})
+			WHERE thing:Brand OR thing:DeletedBrand
+			OPTIONAL MATCH (thing)<-[:IDENTIFIES]-(id:Identifier)
+			WITH thing, size([l IN labels(thing) WHERE l IN ['Brand', 'Concept', 'Classification', 'DeletedBrand']]) AS labelsRemoved,
+				collect(id) AS ids
+			DETACH DELETE thing
+			FOREACH (id IN ids | DELETE id)
+			RETURN labelsRemoved, size(ids) AS identifiersRemoved`,
+		Parameters: neoism.Props{
+			"uuid": uuid,
+		},
+		Result: &removed,
+	}
+
+	if err := s.conn.CypherBatch(append([]*neoism.CypherQuery{findRelationships, deleteNode}, history...)); err != nil {
+		return outcome, err
+	}
+
+	if len(removed) > 0 {
+		outcome.LabelsRemoved = removed[0].LabelsRemoved > 0
+		outcome.NodeDeleted = true
+		outcome.IdentifiersRemoved = removed[0].IdentifiersRemoved
+		outcome.ForeignRelationships = len(dropped)
+		outcome.DroppedRelationships = dropped
+	}
+	return outcome, nil
+}
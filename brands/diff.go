@@ -0,0 +1,96 @@
+package brands
+
+// BrandDiff is what writing a brand would change about the stored brand. Stored is false when there is no
+// stored brand, in which case everything in the brand counts as added.
+type BrandDiff struct {
+	UUID        string                 `json:"uuid"`
+	Stored      bool                   `json:"stored"`
+	Changed     bool                   `json:"changed"`
+	Fields      map[string]FieldChange `json:"fields"`
+	Aliases     ListChange             `json:"aliases"`
+	Identifiers map[string]ListChange  `json:"identifiers"`
+}
+
+// FieldChange is a property, or the parent, going from one value to another
+type FieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ListChange lists the values added to and removed from a list, such as aliases or identifiers
+type ListChange struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+func (c ListChange) empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0
+}
+
+// Diff validates the brand and compares it with the stored brand, as read by Read, without writing anything.
+// It returns a ValidationError for an invalid brand.
+func (s service) Diff(brand Brand) (BrandDiff, error) {
+	diff := BrandDiff{UUID: brand.UUID, Fields: map[string]FieldChange{}}
+	if err := brand.Validate(); err != nil {
+		return diff, err
+	}
+
+	thing, found, err := s.Read(brand.UUID)
+	if err != nil {
+		return diff, err
+	}
+	stored := Brand{}
+	// a brand merged into another reads as that brand, but writing it would be a new brand
+	if found && thing.(Brand).UUID == brand.UUID {
+		stored = thing.(Brand)
+		diff.Stored = true
+	}
+
+	for _, field := range []struct {
+		name     string
+		from, to string
+	}{
+		{"prefLabel", stored.PrefLabel, brand.PrefLabel},
+		{"strapline", stored.Strapline, brand.Strapline},
+		{"description", stored.Description, brand.Description},
+		{"descriptionXML", stored.DescriptionXML, brand.DescriptionXML},
+		{"_imageUrl", stored.ImageURL, brand.ImageURL},
+		{"parentUUID", stored.ParentUUID, brand.ParentUUID},
+	} {
+		if field.from != field.to {
+			diff.Fields[field.name] = FieldChange{From: field.from, To: field.to}
+		}
+	}
+
+	diff.Aliases = listChange(stored.Aliases, brand.Aliases)
+	diff.Identifiers = map[string]ListChange{
+		identifierAuthorities[tmeIdentifierLabel]: listChange(stored.AlternativeIdentifiers.TME, brand.AlternativeIdentifiers.TME),
+		identifierAuthorities[uppIdentifierLabel]: listChange(stored.AlternativeIdentifiers.UUIDS, brand.AlternativeIdentifiers.UUIDS),
+	}
+
+	diff.Changed = !diff.Stored || len(diff.Fields) > 0 || !diff.Aliases.empty()
+	for _, change := range diff.Identifiers {
+		diff.Changed = diff.Changed || !change.empty()
+	}
+	return diff, nil
+}
+
+// listChange returns the values in to that aren't in from, and those in from that aren't in to, each sorted
+func listChange(from []string, to []string) ListChange {
+	return ListChange{Added: missingFrom(to, from), Removed: missingFrom(from, to)}
+}
+
+func missingFrom(values []string, others []string) []string {
+	seen := map[string]bool{}
+	for _, other := range others {
+		seen[other] = true
+	}
+	missing := []string{}
+	for _, value := range sortedCopy(values) {
+		if !seen[value] {
+			missing = append(missing, value)
+			seen[value] = true
+		}
+	}
+	return missing
+}
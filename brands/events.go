@@ -0,0 +1,88 @@
+package brands
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+)
+
+var failedEvents = metrics.GetOrRegisterCounter("brands.events.failed", metrics.DefaultRegistry)
+
+// ChangeEvent announces a change made to a brand. Type is the operation that made it, as recorded in the
+// brand's history, and Payload is the brand as written, or nil for a delete. The brand merged away by a merge
+// gives the brand it was merged into as MergedInto.
+type ChangeEvent struct {
+	UUID          string   `json:"uuid"`
+	Type          string   `json:"type"`
+	TransactionID string   `json:"transactionId"`
+	Timestamp     string   `json:"timestamp"`
+	ChangedFields []string `json:"changedFields"`
+	MergedInto    string   `json:"mergedInto,omitempty"`
+	Payload       *Brand   `json:"payload"`
+}
+
+// EventSink is where the service sends change events, once the changes they announce have been made
+type EventSink interface {
+	Publish(events []ChangeEvent) error
+}
+
+// WithEventSink returns a copy of the service that publishes the changes it makes to the sink. A nil sink
+// publishes nothing.
+func (s service) WithEventSink(sink EventSink) service {
+	s.events = sink
+	return s
+}
+
+func newChangeEvent(change BrandChange) ChangeEvent {
+	return ChangeEvent{
+		UUID:          change.UUID,
+		Type:          change.Operation,
+		TransactionID: change.TransactionID,
+		Timestamp:     change.Timestamp,
+		ChangedFields: change.ChangedFields,
+		MergedInto:    change.MergedInto,
+		Payload:       change.Current,
+	}
+}
+
+// publish sends the changes to the event sink. The changes have already been made by then, so a sink that
+// fails is logged and counted rather than failing the write.
+func (s service) publish(changes ...BrandChange) {
+	if s.events == nil || len(changes) == 0 {
+		return
+	}
+	events := make([]ChangeEvent, len(changes))
+	for i, change := range changes {
+		events[i] = newChangeEvent(change)
+	}
+	if err := s.events.Publish(events); err != nil {
+		failedEvents.Inc(int64(len(events)))
+		log.Errorf("Failed to publish %d brand change events, transaction_id=%s, err=%s", len(events), s.transactionID, err)
+	}
+}
+
+// WriterSink writes each event as a line of JSON, to stdout or a file say
+type WriterSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewWriterSink returns a sink writing events to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{encoder: json.NewEncoder(w)}
+}
+
+// Publish writes the events in order, stopping at the first that can't be written
+func (s *WriterSink) Publish(events []ChangeEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range events {
+		if err := s.encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
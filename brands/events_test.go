@@ -0,0 +1,50 @@
+package brands
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink keeps the events published to it, for tests to check
+type recordingSink struct {
+	mu     sync.Mutex
+	events []ChangeEvent
+	err    error
+}
+
+func (s *recordingSink) Publish(events []ChangeEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func TestWriterSinkWritesEventsAsJSONLines(t *testing.T) {
+	assert := assert.New(t)
+	var out bytes.Buffer
+	sink := NewWriterSink(&out)
+
+	written := ChangeEvent{UUID: validSimpleBrandUuid, Type: WriteOperation, ChangedFields: []string{"prefLabel"}, Payload: &validSimpleBrand}
+	deleted := ChangeEvent{UUID: validSimpleBrandUuid, Type: DeleteOperation, ChangedFields: []string{"prefLabel"}}
+	assert.NoError(sink.Publish([]ChangeEvent{written}))
+	assert.NoError(sink.Publish([]ChangeEvent{deleted}))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !assert.Len(lines, 2) {
+		return
+	}
+	for i, expected := range []ChangeEvent{written, deleted} {
+		event := ChangeEvent{}
+		assert.NoError(json.Unmarshal([]byte(lines[i]), &event))
+		assert.Equal(expected, event)
+	}
+	assert.Contains(lines[1], `"payload":null`)
+}
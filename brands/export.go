@@ -0,0 +1,43 @@
+package brands
+
+import (
+	"github.com/jmcvetta/neoism"
+)
+
+// Export calls handle with every brand, in uuid order, reading pageSize brands from Neo4j at a time.
+// It stops at the first error from Neo4j or from handle.
+func (s service) Export(pageSize int, handle func(Brand) error) error {
+	after := ""
+	for {
+		results := []struct {
+			Brand
+		}{}
+		query := &neoism.CypherQuery{
+			Statement: `
+                        MATCH (n:Brand)
+                        WHERE n.uuid > {after}
+                        WITH n
+                        ORDER BY n.uuid
+                        LIMIT {limit}` + brandProjection + `
+                        ORDER BY uuid`,
+			Parameters: neoism.Props{
+				"after": after,
+				"limit": pageSize,
+			},
+			Result: &results,
+		}
+		if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			if err := handle(result.Brand); err != nil {
+				return err
+			}
+		}
+		if len(results) < pageSize {
+			return nil
+		}
+		after = results[len(results)-1].UUID
+	}
+}
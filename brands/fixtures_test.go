@@ -0,0 +1,76 @@
+package brands
+
+var defaultTypes = []string{"Thing", "Brand", "Concept", "Classification"}
+
+const (
+	validSkeletonBrandUuid = "92f4ec09-436d-4092-a88c-96f54e34007d"
+	validSimpleBrandUuid   = "92f4ec09-436d-4092-a88c-96f54e34007c"
+	validChildBrandUuid    = "a806e270-edbc-423f-b8db-d21ae90e06c8"
+	specialCharBrandUuid   = "327af339-39d4-4c7b-8c06-9f80211ea93d"
+	contentUuid            = "3fc9fe3e-af8c-4f7f-961a-e5065392bb31"
+	parentBrandUuid        = "92f4ec09-436d-4092-a88c-96f54e34007c"
+
+	// small enough that bulk writes of the test brands need several batches
+	testBatchSize = 8
+)
+
+var validSkeletonBrand = Brand{
+	UUID:      validSkeletonBrandUuid,
+	PrefLabel: "validSkeletonBrand",
+	AlternativeIdentifiers: alternativeIdentifiers{
+		TME:   []string{"111"},
+		UUIDS: []string{"92f4ec09-436d-4092-a88c-96f54e34007d"},
+	},
+	Types: defaultTypes,
+}
+
+var updatedSkeletonBrand = Brand{
+	UUID:      validSkeletonBrandUuid,
+	PrefLabel: "validSkeletonBrand",
+	AlternativeIdentifiers: alternativeIdentifiers{
+		TME:   []string{"123"},
+		UUIDS: []string{"92f4ec09-436d-4092-a88c-96f54e34007d"},
+	},
+	Types: defaultTypes,
+}
+
+var validSimpleBrand = Brand{
+	UUID:           validSimpleBrandUuid,
+	PrefLabel:      "validSimpleBrand",
+	Strapline:      "Keeping it simple",
+	Description:    "This brand has no parent but otherwise has valid values for all fields",
+	DescriptionXML: "<body>This <i>brand</i> has no parent but otherwise has valid values for all fields</body>",
+	ImageURL:       "http://media.ft.com/validSimpleBrand.png",
+	AlternativeIdentifiers: alternativeIdentifiers{
+		TME:   []string{"123"},
+		UUIDS: []string{"92f4ec09-436d-4092-a88c-96f54e34007c"},
+	},
+	Types: defaultTypes,
+}
+
+var validChildBrand = Brand{
+	UUID:           validChildBrandUuid,
+	ParentUUID:     parentBrandUuid,
+	PrefLabel:      "validChildBrand",
+	Strapline:      "My parent is simple",
+	Description:    "This brand has a parent and valid values for all fields",
+	DescriptionXML: "<body>This <i>brand</i> has a parent and valid values for all fields</body>",
+	ImageURL:       "http://media.ft.com/validChildBrand.png",
+	AlternativeIdentifiers: alternativeIdentifiers{
+		TME:   []string{"123123"},
+		UUIDS: []string{"a806e270-edbc-423f-b8db-d21ae90e06c8"},
+	},
+	Types:   defaultTypes,
+	Aliases: []string{"SomeWonkyBrand", "AnotherAliasForABrand"},
+}
+
+var specialCharBrand = Brand{
+	UUID:        specialCharBrandUuid,
+	PrefLabel:   "specialCharBrand",
+	Description: "This brand has a heart \u2665 and smiley \u263A",
+	AlternativeIdentifiers: alternativeIdentifiers{
+		TME:   []string{"1111"},
+		UUIDS: []string{"327af339-39d4-4c7b-8c06-9f80211ea93d"},
+	},
+	Types: defaultTypes,
+}
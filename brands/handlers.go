@@ -0,0 +1,711 @@
+package brands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// exportPageSize is how many brands an export reads from Neo4j at a time
+	exportPageSize = 500
+	// idsPageSize is how many uuids the __ids endpoint reads from Neo4j at a time
+	idsPageSize = 5000
+)
+
+// BrandsHandler serves the brand-specific /brands endpoints, leaving the rest to baseftrwapp. GET, PUT and
+// DELETE of a brand are served here too, as their responses carry versions, write results and delete outcomes
+// that baseftrwapp's don't.
+type BrandsHandler struct {
+	s                service
+	maxSyncDeletions int
+}
+
+// NewBrandsHandler returns a handler for the given brands service. maxSyncDeletions is the most brands
+// a full sync may delete unless the request asks for a higher limit.
+func NewBrandsHandler(s service, maxSyncDeletions int) BrandsHandler {
+	return BrandsHandler{s, maxSyncDeletions}
+}
+
+// RegisterHandlers adds the brand routes to the router
+func (h BrandsHandler) RegisterHandlers(router *mux.Router) {
+	router.HandleFunc("/brands/__bulk", h.BulkWriteBrands).Methods("POST")
+	router.HandleFunc("/brands/__export", h.ExportBrands).Methods("GET")
+	router.HandleFunc("/brands/__ids", h.BrandIDs).Methods("GET")
+	router.HandleFunc("/brands/__sync", h.SyncBrands).Methods("POST")
+	router.HandleFunc("/brands/__integrity", h.CheckIntegrity).Methods("GET")
+	router.HandleFunc("/brands/__integrity", h.RepairIntegrity).Methods("POST")
+	router.HandleFunc("/brands/__batch", h.GetBrands).Methods("GET")
+	router.HandleFunc("/brands/__search", h.SearchBrands).Methods("GET")
+	router.HandleFunc("/brands/__lookup", h.LookupBrands).Methods("GET")
+	router.HandleFunc("/brands/__merge", h.MergeBrands).Methods("POST")
+	router.HandleFunc("/brands/__admin/{uuid}", h.ForceDeleteBrand).Methods("DELETE")
+	router.HandleFunc("/brands/{uuid}/history", h.GetHistory).Methods("GET")
+	router.HandleFunc("/brands/{uuid}/rollback", h.RollbackBrand).Methods("POST")
+	router.HandleFunc("/brands/{uuid}/diff", h.DiffBrand).Methods("POST")
+	router.HandleFunc("/brands/{uuid}/ancestors", h.GetAncestors).Methods("GET")
+	router.HandleFunc("/brands/{uuid}/children", h.GetChildren).Methods("GET")
+	router.HandleFunc("/brands/{uuid}/descendants", h.GetDescendants).Methods("GET")
+	router.HandleFunc("/brands/{uuid}", h.GetBrand).Methods("GET")
+	router.HandleFunc("/brands/{uuid}", h.PutBrand).Methods("PUT")
+	router.HandleFunc("/brands/{uuid}", h.DeleteBrand).Methods("DELETE")
+}
+
+// GetBrand returns the brand stored for the uuid
+func (h BrandsHandler) GetBrand(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+
+	if asOf := r.URL.Query().Get("asOf"); asOf != "" {
+		h.getBrandAsOf(w, uuid, asOf)
+		return
+	}
+
+	brand, version, found, err := h.s.ReadVersion(uuid)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Error getting brand with uuid %s, err=%s", uuid, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	if !found {
+		writeJSONError(w, fmt.Sprintf("Brand with uuid %s not found", uuid), http.StatusNotFound)
+		return
+	}
+
+	if version != "" {
+		w.Header().Set("ETag", etag(version))
+	}
+	if !(Precondition{IfMatch: parseETags(r.Header.Get("If-Match"))}).allows(version, true) {
+		writeJSON(w, map[string]interface{}{"message": PreconditionFailedError{UUID: uuid, Version: version, Exists: true}.Error(), "version": version}, http.StatusPreconditionFailed)
+		return
+	}
+	if !(Precondition{IfNoneMatch: parseETags(r.Header.Get("If-None-Match"))}).allows(version, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, brand, http.StatusOK)
+}
+
+// getBrandAsOf returns the brand as it was at the asOf time, an RFC 3339 timestamp
+func (h BrandsHandler) getBrandAsOf(w http.ResponseWriter, uuid string, asOf string) {
+	at, err := time.Parse(time.RFC3339Nano, asOf)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid asOf %q, it must be an RFC 3339 timestamp", asOf), http.StatusBadRequest)
+		return
+	}
+
+	brand, found, err := h.s.ReadAsOf(uuid, at)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Error getting brand with uuid %s, err=%s", uuid, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	if !found {
+		writeJSONError(w, fmt.Sprintf("Brand with uuid %s not found as of %s", uuid, asOf), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, brand, http.StatusOK)
+}
+
+// GetHistory returns the changes made to the brand, oldest first
+func (h BrandsHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+
+	changes, err := h.s.History(uuid)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Error getting history of brand with uuid %s, err=%s", uuid, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	if len(changes) == 0 {
+		writeJSONError(w, fmt.Sprintf("No history for brand with uuid %s", uuid), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, changes, http.StatusOK)
+}
+
+// GetAncestors returns the brand's parent, grandparent and so on up to the root of the hierarchy
+func (h BrandsHandler) GetAncestors(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+
+	ancestors, found, err := h.s.Ancestors(uuid)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Error getting ancestors of brand with uuid %s, err=%s", uuid, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	if !found {
+		writeJSONError(w, fmt.Sprintf("Brand with uuid %s not found", uuid), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, ancestors, http.StatusOK)
+}
+
+// GetChildren returns the brands whose parent is the brand
+func (h BrandsHandler) GetChildren(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+
+	children, found, err := h.s.Children(uuid)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Error getting children of brand with uuid %s, err=%s", uuid, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	if !found {
+		writeJSONError(w, fmt.Sprintf("Brand with uuid %s not found", uuid), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, children, http.StatusOK)
+}
+
+// GetDescendants returns the brand with its children nested inside it, their children nested inside them and
+// so on. The optional depth parameter limits how many levels below the brand are included.
+func (h BrandsHandler) GetDescendants(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+	depth := -1
+	if d := r.URL.Query().Get("depth"); d != "" {
+		var err error
+		if depth, err = strconv.Atoi(d); err != nil || depth < 0 {
+			writeJSONError(w, fmt.Sprintf("Invalid depth %q, it must be zero or more", d), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tree, found, err := h.s.Descendants(uuid, depth)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Error getting descendants of brand with uuid %s, err=%s", uuid, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	if !found {
+		writeJSONError(w, fmt.Sprintf("Brand with uuid %s not found", uuid), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, tree, http.StatusOK)
+}
+
+// PutBrand creates or replaces the brand for the uuid
+func (h BrandsHandler) PutBrand(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+
+	brand, docUUID, err := h.s.DecodeJSON(json.NewDecoder(r.Body))
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if docUUID != uuid {
+		writeJSONError(w, fmt.Sprintf("Uuids from payload and request, respectively, do not match: '%v' '%v'", docUUID, uuid), http.StatusBadRequest)
+		return
+	}
+
+	precondition := Precondition{
+		IfMatch:     parseETags(r.Header.Get("If-Match")),
+		IfNoneMatch: parseETags(r.Header.Get("If-None-Match")),
+	}
+	status, err := h.changes(r).WriteBrandIf(brand.(Brand), precondition)
+	if err != nil {
+		writeWriteError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(brand.(Brand).contentHash()))
+	writeJSON(w, WriteResult{UUID: uuid, Status: status}, http.StatusOK)
+}
+
+// DiffBrand responds with what a PUT of the brand in the body would change, without writing it
+func (h BrandsHandler) DiffBrand(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+
+	brand, docUUID, err := h.s.DecodeJSON(json.NewDecoder(r.Body))
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if docUUID != uuid {
+		writeJSONError(w, fmt.Sprintf("Uuids from payload and request, respectively, do not match: '%v' '%v'", docUUID, uuid), http.StatusBadRequest)
+		return
+	}
+
+	diff, err := h.s.Diff(brand.(Brand))
+	if err != nil {
+		writeWriteError(w, err)
+		return
+	}
+	writeJSON(w, diff, http.StatusOK)
+}
+
+// RollbackBrand writes the brand as it was after the version parameter's change in its history, or as it was at
+// the asOf time
+func (h BrandsHandler) RollbackBrand(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+	v, asOf := r.URL.Query().Get("version"), r.URL.Query().Get("asOf")
+	if (v == "") == (asOf == "") {
+		writeJSONError(w, "Exactly one of version and asOf is required", http.StatusBadRequest)
+		return
+	}
+
+	var result RollbackResult
+	var err error
+	if v != "" {
+		version, convErr := strconv.Atoi(v)
+		if convErr != nil || version < 1 {
+			writeJSONError(w, fmt.Sprintf("Invalid version %q, it must be a positive number", v), http.StatusBadRequest)
+			return
+		}
+		result, err = h.changes(r).Rollback(uuid, version)
+	} else {
+		at, parseErr := time.Parse(time.RFC3339Nano, asOf)
+		if parseErr != nil {
+			writeJSONError(w, fmt.Sprintf("Invalid asOf %q, it must be an RFC 3339 timestamp", asOf), http.StatusBadRequest)
+			return
+		}
+		result, err = h.changes(r).RollbackAsOf(uuid, at)
+	}
+
+	if e, ok := err.(RollbackError); ok {
+		writeJSONError(w, e.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		writeWriteError(w, err)
+		return
+	}
+	writeJSON(w, result, http.StatusOK)
+}
+
+// writeWriteError responds to a failed write of a single brand, with the details of what was wrong with it.
+// Constraint violations and failed transactions are conflicts, as they are in baseftrwapp.
+func writeWriteError(w http.ResponseWriter, err error) {
+	switch e := err.(type) {
+	case rwapi.ConstraintOrTransactionError:
+		writeJSONError(w, e.Error(), http.StatusConflict)
+	case PreconditionFailedError:
+		writeJSON(w, map[string]interface{}{"message": e.Error(), "version": e.Version}, http.StatusPreconditionFailed)
+	case ValidationError:
+		writeJSON(w, map[string]interface{}{"message": e.Error(), "errors": e.Errors}, http.StatusBadRequest)
+	case IdentifierConflictError:
+		writeJSON(w, map[string]interface{}{"message": e.Error(), "conflicts": e.Conflicts}, http.StatusConflict)
+	case CycleError:
+		writeJSON(w, map[string]interface{}{"message": e.Error(), "cycle": e.Path}, http.StatusConflict)
+	case MergedBrandError:
+		writeJSON(w, map[string]interface{}{"message": e.Error(), "target": e.Target}, http.StatusConflict)
+	case MissingParentError:
+		writeJSON(w, map[string]interface{}{"message": e.Error(), "parentUUID": e.ParentUUID}, http.StatusUnprocessableEntity)
+	default:
+		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// DeleteBrand removes the brand for the uuid and responds with what was deleted. The optional mode parameter
+// chooses a soft or conditional delete; forced deletes are only allowed through ForceDeleteBrand.
+func (h BrandsHandler) DeleteBrand(w http.ResponseWriter, r *http.Request) {
+	mode, err := ParseDeleteMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if mode == ForcedDelete {
+		writeJSONError(w, "Forced deletes are only allowed on the admin endpoint /brands/__admin/{uuid}", http.StatusForbidden)
+		return
+	}
+	h.deleteBrand(w, r, mode)
+}
+
+// ForceDeleteBrand deletes the brand's node whatever is related to it, and responds with the relationships
+// that were dropped
+func (h BrandsHandler) ForceDeleteBrand(w http.ResponseWriter, r *http.Request) {
+	h.deleteBrand(w, r, ForcedDelete)
+}
+
+func (h BrandsHandler) deleteBrand(w http.ResponseWriter, r *http.Request, mode DeleteMode) {
+	uuid := mux.Vars(r)["uuid"]
+	outcome, err := h.changes(r).DeleteBrand(uuid, mode)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if !outcome.LabelsRemoved && !outcome.NodeDeleted {
+		writeJSONError(w, fmt.Sprintf("Brand with uuid %s not found", uuid), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, outcome, http.StatusOK)
+}
+
+// BulkWriteBrands writes every brand in the body, which is either a JSON array of brands or newline-delimited
+// JSON with one brand per line, and responds with a result for each brand in the order they were sent.
+func (h BrandsHandler) BulkWriteBrands(w http.ResponseWriter, r *http.Request) {
+	records, err := splitRecords(r.Body)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid bulk body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]WriteResult, len(records))
+	var brands []Brand
+	var positions []int
+	for i, record := range records {
+		brand, uuid, err := h.s.DecodeJSON(json.NewDecoder(bytes.NewReader(record)))
+		if err != nil {
+			results[i] = WriteResult{UUID: uuid, Status: Rejected, Reason: err.Error()}
+			continue
+		}
+		brands = append(brands, brand.(Brand))
+		positions = append(positions, i)
+	}
+	if duplicates := duplicateUUIDs(brands); len(duplicates) > 0 {
+		writeJSONError(w, fmt.Sprintf("Brands can only appear once in a bulk body, but these appear more than once: %s",
+			strings.Join(duplicates, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	for i, result := range h.changes(r).WriteAll(brands) {
+		results[positions[i]] = result
+	}
+	writeJSON(w, results, http.StatusOK)
+}
+
+// duplicateUUIDs returns the uuids that more than one of the brands has, in the order they first repeat
+func duplicateUUIDs(brands []Brand) []string {
+	counts := map[string]int{}
+	var duplicates []string
+	for _, brand := range brands {
+		counts[brand.UUID]++
+		if counts[brand.UUID] == 2 && brand.UUID != "" {
+			duplicates = append(duplicates, brand.UUID)
+		}
+	}
+	return duplicates
+}
+
+// ExportBrands streams every brand as newline-delimited JSON, in uuid order
+func (h BrandsHandler) ExportBrands(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	err := h.s.Export(exportPageSize, func(brand Brand) error {
+		if !started {
+			start()
+		}
+		return enc.Encode(brand)
+	})
+
+	switch {
+	case err == nil && !started:
+		start()
+	case err != nil && !started:
+		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+	case err != nil:
+		// too late to change the status, so the export is cut short
+		log.Errorf("Brands export stopped part way through, err=%s", err)
+	}
+}
+
+// BrandIDs streams {"id": uuid} lines for every brand, in uuid order. The optional after parameter starts
+// the list after that uuid and limit caps how many are returned, so a client can page through by passing
+// the last id it received as the next after.
+func (h BrandsHandler) BrandIDs(w http.ResponseWriter, r *http.Request) {
+	after := r.URL.Query().Get("after")
+	limit := -1
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			writeJSONError(w, fmt.Sprintf("Invalid limit %q, it must be a positive number", l), http.StatusBadRequest)
+			return
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	started := false
+	for limit != 0 {
+		pageSize := idsPageSize
+		if limit > 0 && limit < pageSize {
+			pageSize = limit
+		}
+
+		ids, err := h.s.IDs(after, pageSize)
+		if err != nil {
+			if !started {
+				writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			log.Errorf("Brand ids listing stopped part way through, err=%s", err)
+			return
+		}
+
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		for _, id := range ids {
+			enc.Encode(map[string]string{"id": id})
+		}
+
+		if len(ids) < pageSize {
+			return
+		}
+		after = ids[len(ids)-1]
+		if limit > 0 {
+			limit -= len(ids)
+		}
+	}
+}
+
+// SyncBrands takes the complete set of brands, either as brands or as their uuids, and deletes every stored
+// brand not in it. With dryRun=true it only reports what would be deleted. A sync that would delete more than
+// the configured maximum (which maxDeletions can raise for one request) deletes nothing and returns 422.
+func (h BrandsHandler) SyncBrands(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	maxDeletions := h.maxSyncDeletions
+	if m := r.URL.Query().Get("maxDeletions"); m != "" {
+		var err error
+		if maxDeletions, err = strconv.Atoi(m); err != nil || maxDeletions < 0 {
+			writeJSONError(w, fmt.Sprintf("Invalid maxDeletions %q, it must be zero or more", m), http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := splitRecords(r.Body)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid sync body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	keep := make([]string, len(records))
+	for i, record := range records {
+		if keep[i], err = recordUUID(record); err != nil {
+			writeJSONError(w, fmt.Sprintf("Invalid sync body: record %d: %s", i, err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := h.changes(r).Sync(keep, maxDeletions, dryRun)
+	switch err.(type) {
+	case nil:
+		writeJSON(w, report, http.StatusOK)
+	case TooManyDeletionsError:
+		writeJSON(w, report, http.StatusUnprocessableEntity)
+	default:
+		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// CheckIntegrity reports placeholder parents, dangling identifiers and brands missing their own identifier
+func (h BrandsHandler) CheckIntegrity(w http.ResponseWriter, r *http.Request) {
+	h.integrity(w, false)
+}
+
+// RepairIntegrity reports the same anomalies as CheckIntegrity and then fixes them
+func (h BrandsHandler) RepairIntegrity(w http.ResponseWriter, r *http.Request) {
+	h.integrity(w, true)
+}
+
+func (h BrandsHandler) integrity(w http.ResponseWriter, repair bool) {
+	report, err := h.s.CheckIntegrity(repair)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, report, http.StatusOK)
+}
+
+// GetBrands returns the brand for each uuid parameter, keyed by uuid, with null for those that aren't brands
+func (h BrandsHandler) GetBrands(w http.ResponseWriter, r *http.Request) {
+	uuids := r.URL.Query()["uuid"]
+	if len(uuids) == 0 {
+		writeJSONError(w, "At least one uuid is required", http.StatusBadRequest)
+		return
+	}
+
+	brands, err := h.s.ReadMany(uuids)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, brands, http.StatusOK)
+}
+
+// SearchBrands returns the brands whose prefLabel or aliases best match the q parameter. The distance
+// parameter is the most edits a fuzzy match may need, 2 by default, and limit is the most brands returned,
+// 10 by default.
+func (h BrandsHandler) SearchBrands(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeJSONError(w, "A q parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	distance := 2
+	if d := r.URL.Query().Get("distance"); d != "" {
+		var err error
+		if distance, err = strconv.Atoi(d); err != nil || distance < 0 {
+			writeJSONError(w, fmt.Sprintf("Invalid distance %q, it must be zero or more", d), http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := 10
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			writeJSONError(w, fmt.Sprintf("Invalid limit %q, it must be a positive number", l), http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := h.s.Search(query, distance, limit)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, results, http.StatusOK)
+}
+
+// LookupBrands returns the brands identified by each value parameter, which are identifiers from the
+// authority parameter
+func (h BrandsHandler) LookupBrands(w http.ResponseWriter, r *http.Request) {
+	authority := r.URL.Query().Get("authority")
+	values := r.URL.Query()["value"]
+	if len(values) == 0 {
+		writeJSONError(w, "At least one value is required", http.StatusBadRequest)
+		return
+	}
+
+	matches, err := h.s.Lookup(authority, values)
+	switch err.(type) {
+	case nil:
+		writeJSON(w, matches, http.StatusOK)
+	case UnknownAuthorityError:
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// MergeBrands merges the source brand named in the body into the target brand, responding with what was moved
+func (h BrandsHandler) MergeBrands(w http.ResponseWriter, r *http.Request) {
+	merge := struct {
+		Source string `json:"source"`
+		Target string `json:"target"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&merge); err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid merge body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.changes(r).Merge(merge.Source, merge.Target)
+	switch err.(type) {
+	case nil:
+		writeJSON(w, result, http.StatusOK)
+	case MergeError:
+		writeJSONError(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// recordUUID returns the uuid a sync record names; the record is either a uuid string or a brand
+func recordUUID(record json.RawMessage) (string, error) {
+	var uuid string
+	if len(record) > 0 && record[0] == '"' {
+		if err := json.Unmarshal(record, &uuid); err != nil {
+			return "", err
+		}
+	} else {
+		brand := struct {
+			UUID string `json:"uuid"`
+		}{}
+		if err := json.Unmarshal(record, &brand); err != nil {
+			return "", err
+		}
+		uuid = brand.UUID
+	}
+	if uuid == "" {
+		return "", errors.New("uuid is required")
+	}
+	return uuid, nil
+}
+
+// splitRecords separates a JSON array or a newline-delimited JSON stream into its records
+func splitRecords(body io.Reader) ([]json.RawMessage, error) {
+	reader := bufio.NewReader(body)
+	var first byte
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			first = b
+			reader.UnreadByte()
+			break
+		}
+	}
+
+	var records []json.RawMessage
+	dec := json.NewDecoder(reader)
+
+	if first == '[' {
+		err := dec.Decode(&records)
+		return records, err
+	}
+
+	for {
+		var record json.RawMessage
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, msg string, status int) {
+	writeJSON(w, map[string]string{"message": msg}, status)
+}
+
+// changes returns the service to make changes with, recording the request's X-Request-Id against them, or a
+// generated transaction id if it has none
+func (h BrandsHandler) changes(r *http.Request) service {
+	return h.s.WithTransactionID(transactionidutils.GetTransactionIDFromRequest(r))
+}
+
+// etag quotes a brand version for the ETag header
+func etag(version string) string {
+	return `"` + version + `"`
+}
+
+// parseETags returns the versions listed in an If-Match or If-None-Match header. Weak tags are taken as
+// strong ones, since a brand's version only changes with its content.
+func parseETags(header string) []string {
+	var versions []string
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag != "" {
+			versions = append(versions, strings.Trim(tag, `"`))
+		}
+	}
+	return versions
+}
@@ -0,0 +1,727 @@
+//go:build !jenkins
+// +build !jenkins
+
+package brands
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	"github.com/gorilla/mux"
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMaxSyncDeletions = 1
+
+func newTestRouter() (*mux.Router, service) {
+	s := getCypherDriver(newFakeNeoConnection())
+	router := mux.NewRouter()
+	NewBrandsHandler(s, testMaxSyncDeletions).RegisterHandlers(router)
+	return router, s
+}
+
+func doRequest(router *mux.Router, method string, path string, body string) *httptest.ResponseRecorder {
+	return doRequestWithHeaders(router, method, path, body, nil)
+}
+
+func doRequestWithHeaders(router *mux.Router, method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func brandJSON(t *testing.T, brand Brand) string {
+	b, err := json.Marshal(brand)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestPutGetAndDeleteBrand(t *testing.T) {
+	assert := assert.New(t)
+	router, _ := newTestRouter()
+
+	rec := doRequest(router, "PUT", "/brands/"+validSimpleBrandUuid, brandJSON(t, validSimpleBrand))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"uuid": "`+validSimpleBrandUuid+`", "status": "written"}`, rec.Body.String())
+
+	rec = doRequest(router, "PUT", "/brands/"+validSimpleBrandUuid, brandJSON(t, validSimpleBrand))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"uuid": "`+validSimpleBrandUuid+`", "status": "unchanged"}`, rec.Body.String())
+
+	rec = doRequest(router, "GET", "/brands/"+validSimpleBrandUuid, "")
+	assert.Equal(http.StatusOK, rec.Code)
+	var brand Brand
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &brand))
+	assert.Equal(validSimpleBrand.PrefLabel, brand.PrefLabel)
+
+	rec = doRequest(router, "DELETE", "/brands/"+validSimpleBrandUuid, "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"uuid": "`+validSimpleBrandUuid+`", "mode": "conditional", "labelsRemoved": true, "nodeDeleted": true, "foreignRelationships": 0, "identifiersRemoved": 2}`, rec.Body.String())
+
+	rec = doRequest(router, "DELETE", "/brands/"+validSimpleBrandUuid, "")
+	assert.Equal(http.StatusNotFound, rec.Code)
+
+	rec = doRequest(router, "GET", "/brands/"+validSimpleBrandUuid, "")
+	assert.Equal(http.StatusNotFound, rec.Code)
+}
+
+func TestPutBrandWithMismatchedUuidIsBadRequest(t *testing.T) {
+	router, _ := newTestRouter()
+
+	rec := doRequest(router, "PUT", "/brands/"+validChildBrandUuid, brandJSON(t, validSimpleBrand))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPutInvalidBrandIsBadRequestListingEveryProblem(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+
+	invalid := validChildBrand
+	invalid.PrefLabel = ""
+	invalid.ParentUUID = invalid.UUID
+
+	rec := doRequest(router, "PUT", "/brands/"+invalid.UUID, brandJSON(t, invalid))
+	assert.Equal(http.StatusBadRequest, rec.Code)
+
+	body := struct {
+		Errors []FieldError `json:"errors"`
+	}{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal([]FieldError{
+		{Field: "prefLabel", Message: "is required"},
+		{Field: "parentUUID", Message: "must not be the brand's own uuid"},
+	}, body.Errors)
+
+	_, found, err := s.Read(invalid.UUID)
+	assert.NoError(err)
+	assert.False(found)
+}
+
+func TestPutBrandWithClaimedIdentifierIsConflict(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+	assert.NoError(s.Write(validSimpleBrand))
+
+	rec := doRequest(router, "PUT", "/brands/"+validSkeletonBrandUuid, brandJSON(t, updatedSkeletonBrand))
+	assert.Equal(http.StatusConflict, rec.Code)
+
+	body := struct {
+		Conflicts []IdentifierConflict `json:"conflicts"`
+	}{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal([]IdentifierConflict{{Authority: "TME", Value: "123", OwnerUUID: validSimpleBrandUuid}}, body.Conflicts)
+}
+
+func TestPutBrandCreatingParentCycleIsConflict(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+	assert.NoError(s.Write(validSimpleBrand))
+	assert.NoError(s.Write(validChildBrand))
+
+	ownChild := validSimpleBrand
+	ownChild.ParentUUID = validChildBrandUuid
+	rec := doRequest(router, "PUT", "/brands/"+validSimpleBrandUuid, brandJSON(t, ownChild))
+	assert.Equal(http.StatusConflict, rec.Code)
+
+	body := struct {
+		Cycle []string `json:"cycle"`
+	}{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal([]string{validSimpleBrandUuid, validChildBrandUuid, validSimpleBrandUuid}, body.Cycle)
+}
+
+// conflictingNeoConnection fails every batch the way neoutils reports a constraint violation
+type conflictingNeoConnection struct {
+	*fakeNeoConnection
+}
+
+func (conflictingNeoConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	return rwapi.ConstraintOrTransactionError{Message: "Node already exists with label Identifier"}
+}
+
+func TestPutBrandViolatingConstraintIsConflict(t *testing.T) {
+	router := mux.NewRouter()
+	NewBrandsHandler(NewCypherBrandsService(conflictingNeoConnection{newFakeNeoConnection()}, testBatchSize, PlaceholderParents), testMaxSyncDeletions).RegisterHandlers(router)
+
+	rec := doRequest(router, "PUT", "/brands/"+validSimpleBrandUuid, brandJSON(t, validSimpleBrand))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestBulkWriteBrands(t *testing.T) {
+	bodies := map[string]string{
+		"ndjson": brandJSON(t, validSimpleBrand) + "\n" + `{"uuid": 12}` + "\n" + brandJSON(t, validChildBrand) + "\n",
+		"array":  "[" + brandJSON(t, validSimpleBrand) + ",\n" + `{"uuid": 12}` + ",\n" + brandJSON(t, validChildBrand) + "]",
+	}
+
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			router, s := newTestRouter()
+
+			rec := doRequest(router, "POST", "/brands/__bulk", body)
+			assert.Equal(http.StatusOK, rec.Code)
+
+			var results []WriteResult
+			assert.NoError(json.Unmarshal(rec.Body.Bytes(), &results))
+			assert.Len(results, 3)
+			assert.Equal(WriteResult{UUID: validSimpleBrandUuid, Status: Written}, results[0])
+			assert.Equal(Rejected, results[1].Status)
+			assert.Equal(WriteResult{UUID: validChildBrandUuid, Status: Written}, results[2])
+
+			count, err := s.Count()
+			assert.NoError(err)
+			assert.Equal(2, count)
+		})
+	}
+}
+
+func TestBulkWriteBrandsWithMalformedBodyIsBadRequest(t *testing.T) {
+	router, _ := newTestRouter()
+
+	rec := doRequest(router, "POST", "/brands/__bulk", `{"uuid": "`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestBulkWriteBrandsWithRepeatedUUIDIsBadRequest(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+
+	changed := validSimpleBrand
+	changed.Strapline = "Keeping it simpler"
+	body := brandJSON(t, changed) + "\n" + brandJSON(t, validChildBrand) + "\n" + brandJSON(t, validSimpleBrand) + "\n"
+
+	rec := doRequest(router, "POST", "/brands/__bulk", body)
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	assert.Contains(rec.Body.String(), validSimpleBrandUuid)
+
+	count, err := s.Count()
+	assert.NoError(err)
+	assert.Equal(0, count)
+}
+
+func TestExportBrands(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+
+	rec := doRequest(router, "GET", "/brands/__export", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Empty(rec.Body.String())
+
+	assert.NoError(s.Write(validSimpleBrand))
+	assert.NoError(s.Write(specialCharBrand))
+
+	rec = doRequest(router, "GET", "/brands/__export", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("application/x-ndjson", rec.Header().Get("Content-Type"))
+
+	dec := json.NewDecoder(rec.Body)
+	var uuids []string
+	for dec.More() {
+		var brand Brand
+		assert.NoError(dec.Decode(&brand))
+		uuids = append(uuids, brand.UUID)
+	}
+	assert.Equal([]string{specialCharBrandUuid, validSimpleBrandUuid}, uuids)
+}
+
+func TestBrandIDs(t *testing.T) {
+	router, s := newTestRouter()
+	for _, brand := range []Brand{validSkeletonBrand, validSimpleBrand, specialCharBrand} {
+		assert.NoError(t, s.Write(brand))
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		expected string
+	}{
+		{"all", "", http.StatusOK, `{"id":"` + specialCharBrandUuid + `"}` + "\n" + `{"id":"` + validSimpleBrandUuid + `"}` + "\n" + `{"id":"` + validSkeletonBrandUuid + `"}` + "\n"},
+		{"first page", "?limit=2", http.StatusOK, `{"id":"` + specialCharBrandUuid + `"}` + "\n" + `{"id":"` + validSimpleBrandUuid + `"}` + "\n"},
+		{"next page", "?limit=2&after=" + validSimpleBrandUuid, http.StatusOK, `{"id":"` + validSkeletonBrandUuid + `"}` + "\n"},
+		{"past the end", "?after=" + validSkeletonBrandUuid, http.StatusOK, ""},
+		{"bad limit", "?limit=none", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := doRequest(router, "GET", "/brands/__ids"+test.query, "")
+			assert.Equal(t, test.status, rec.Code)
+			if test.status == http.StatusOK {
+				assert.Equal(t, test.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSyncBrands(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		body      string
+		status    int
+		missing   []string
+		remaining []string
+	}{
+		{"brands", "", brandJSON(t, validSimpleBrand) + "\n" + brandJSON(t, specialCharBrand), http.StatusOK,
+			[]string{validSkeletonBrandUuid}, []string{specialCharBrandUuid, validSimpleBrandUuid}},
+		{"uuids", "", `["` + validSimpleBrandUuid + `", "` + specialCharBrandUuid + `"]`, http.StatusOK,
+			[]string{validSkeletonBrandUuid}, []string{specialCharBrandUuid, validSimpleBrandUuid}},
+		{"dry run", "?dryRun=true", `["` + validSimpleBrandUuid + `"]`, http.StatusOK,
+			[]string{specialCharBrandUuid, validSkeletonBrandUuid}, []string{specialCharBrandUuid, validSimpleBrandUuid, validSkeletonBrandUuid}},
+		{"too many deletions", "", `["` + validSimpleBrandUuid + `"]`, http.StatusUnprocessableEntity,
+			[]string{specialCharBrandUuid, validSkeletonBrandUuid}, []string{specialCharBrandUuid, validSimpleBrandUuid, validSkeletonBrandUuid}},
+		{"empty load", "", "", http.StatusUnprocessableEntity,
+			[]string{specialCharBrandUuid, validSimpleBrandUuid, validSkeletonBrandUuid}, []string{specialCharBrandUuid, validSimpleBrandUuid, validSkeletonBrandUuid}},
+		{"raised maximum", "?maxDeletions=2", `["` + validSimpleBrandUuid + `"]`, http.StatusOK,
+			[]string{specialCharBrandUuid, validSkeletonBrandUuid}, []string{validSimpleBrandUuid}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			router, s := newTestRouter()
+			for _, brand := range []Brand{validSkeletonBrand, validSimpleBrand, specialCharBrand} {
+				assert.NoError(s.Write(brand))
+			}
+
+			rec := doRequest(router, "POST", "/brands/__sync"+test.query, test.body)
+			assert.Equal(test.status, rec.Code)
+
+			var report SyncReport
+			assert.NoError(json.Unmarshal(rec.Body.Bytes(), &report))
+			assert.Equal(3, report.Stored)
+			assert.Equal(test.missing, report.Missing)
+
+			remaining, err := s.IDs("", 10)
+			assert.NoError(err)
+			assert.Equal(test.remaining, remaining)
+		})
+	}
+}
+
+func TestSyncBrandsWithRecordMissingUuidIsBadRequest(t *testing.T) {
+	router, _ := newTestRouter()
+
+	rec := doRequest(router, "POST", "/brands/__sync", `[{"prefLabel": "no uuid"}]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestBrandHierarchy(t *testing.T) {
+	router, s := newTestRouter()
+	grandchild := validSkeletonBrand
+	grandchild.ParentUUID = validChildBrandUuid
+	for _, brand := range []Brand{validSimpleBrand, validChildBrand, grandchild} {
+		assert.NoError(t, s.Write(brand))
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		expected string
+	}{
+		{"ancestors", "/brands/" + validSkeletonBrandUuid + "/ancestors", http.StatusOK,
+			`[{"uuid": "` + validChildBrandUuid + `"}, {"uuid": "` + validSimpleBrandUuid + `"}]`},
+		{"children", "/brands/" + validSimpleBrandUuid + "/children", http.StatusOK,
+			`[{"uuid": "` + validChildBrandUuid + `"}]`},
+		{"descendants", "/brands/" + validSimpleBrandUuid + "/descendants", http.StatusOK,
+			`{"uuid": "` + validSimpleBrandUuid + `", "children": [{"uuid": "` + validChildBrandUuid + `", "children": [{"uuid": "` + validSkeletonBrandUuid + `"}]}]}`},
+		{"limited descendants", "/brands/" + validSimpleBrandUuid + "/descendants?depth=1", http.StatusOK,
+			`{"uuid": "` + validSimpleBrandUuid + `", "children": [{"uuid": "` + validChildBrandUuid + `"}]}`},
+		{"bad depth", "/brands/" + validSimpleBrandUuid + "/descendants?depth=-1", http.StatusBadRequest, ""},
+		{"unknown brand", "/brands/" + specialCharBrandUuid + "/ancestors", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := doRequest(router, "GET", test.path, "")
+			assert.Equal(t, test.status, rec.Code)
+			if test.status == http.StatusOK {
+				assert.JSONEq(t, test.expected, uuidsOnly(t, rec.Body.Bytes()))
+			}
+		})
+	}
+}
+
+// uuidsOnly strips a brand, list of brands or brand tree down to its uuids and children
+func uuidsOnly(t *testing.T, body []byte) string {
+	var v interface{}
+	assert.NoError(t, json.Unmarshal(body, &v))
+	var strip func(v interface{}) interface{}
+	strip = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case []interface{}:
+			for i := range v {
+				v[i] = strip(v[i])
+			}
+			return v
+		case map[string]interface{}:
+			stripped := map[string]interface{}{"uuid": v["uuid"]}
+			if children, ok := v["children"]; ok {
+				stripped["children"] = strip(children)
+			}
+			return stripped
+		}
+		return v
+	}
+	b, err := json.Marshal(strip(v))
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestPutBrandWithMissingParentUnderStrictPolicyIsUnprocessable(t *testing.T) {
+	assert := assert.New(t)
+	router := mux.NewRouter()
+	NewBrandsHandler(NewCypherBrandsService(newFakeNeoConnection(), testBatchSize, StrictParents), testMaxSyncDeletions).RegisterHandlers(router)
+
+	rec := doRequest(router, "PUT", "/brands/"+validChildBrandUuid, brandJSON(t, validChildBrand))
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+
+	body := struct {
+		ParentUUID string `json:"parentUUID"`
+	}{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(parentBrandUuid, body.ParentUUID)
+}
+
+func TestCheckAndRepairIntegrity(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+	assert.NoError(s.Write(validChildBrand))
+	found := `[{"uuid": "` + parentBrandUuid + `", "children": ["` + validChildBrandUuid + `"], "otherRelationships": 0}]`
+
+	rec := doRequest(router, "GET", "/brands/__integrity", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"repaired": false, "placeholderParents": `+found+`, "danglingIdentifiers": [], "missingOwnIdentifier": []}`, rec.Body.String())
+
+	rec = doRequest(router, "POST", "/brands/__integrity", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"repaired": true, "placeholderParents": `+found+`, "danglingIdentifiers": [], "missingOwnIdentifier": []}`, rec.Body.String())
+
+	rec = doRequest(router, "GET", "/brands/__integrity", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"repaired": false, "placeholderParents": [], "danglingIdentifiers": [], "missingOwnIdentifier": []}`, rec.Body.String())
+}
+
+// unavailableNeoConnection fails every batch, as a connection to a Neo4j that is down does
+type unavailableNeoConnection struct {
+	*fakeNeoConnection
+}
+
+func (unavailableNeoConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	return errors.New("Neo4j is unavailable")
+}
+
+func TestDeleteBrandWhenNeo4jFailsIsUnavailable(t *testing.T) {
+	router := mux.NewRouter()
+	NewBrandsHandler(NewCypherBrandsService(unavailableNeoConnection{newFakeNeoConnection()}, testBatchSize, PlaceholderParents), testMaxSyncDeletions).RegisterHandlers(router)
+
+	rec := doRequest(router, "DELETE", "/brands/"+validSimpleBrandUuid, "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestDeleteBrandModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		mode   DeleteMode
+		exists bool
+	}{
+		{"conditional by default", "DELETE", "/brands/" + validSimpleBrandUuid, http.StatusOK, ConditionalDelete, false},
+		{"soft", "DELETE", "/brands/" + validSimpleBrandUuid + "?mode=soft", http.StatusOK, SoftDelete, true},
+		{"forced needs the admin endpoint", "DELETE", "/brands/" + validSimpleBrandUuid + "?mode=forced", http.StatusForbidden, "", true},
+		{"forced on the admin endpoint", "DELETE", "/brands/__admin/" + validSimpleBrandUuid, http.StatusOK, ForcedDelete, false},
+		{"unknown mode", "DELETE", "/brands/" + validSimpleBrandUuid + "?mode=later", http.StatusBadRequest, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			router, s := newTestRouter()
+			assert.NoError(s.Write(validSimpleBrand))
+
+			rec := doRequest(router, test.method, test.path, "")
+			assert.Equal(test.status, rec.Code)
+			if test.status == http.StatusOK {
+				var outcome DeleteOutcome
+				assert.NoError(json.Unmarshal(rec.Body.Bytes(), &outcome))
+				assert.Equal(test.mode, outcome.Mode)
+			}
+			assert.Equal(test.exists, doesThingExistAtAll(validSimpleBrandUuid, s.conn, t, assert))
+		})
+	}
+}
+
+func TestMergeBrands(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+	assert.NoError(s.Write(validSimpleBrand))
+	assert.NoError(s.Write(validSkeletonBrand))
+	merge := `{"source": "` + validSimpleBrandUuid + `", "target": "` + validSkeletonBrandUuid + `"}`
+
+	rec := doRequest(router, "POST", "/brands/__merge", merge)
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"source": "`+validSimpleBrandUuid+`", "target": "`+validSkeletonBrandUuid+`", "relationships": {}, "identifiers": 2}`, rec.Body.String())
+
+	rec = doRequest(router, "GET", "/brands/"+validSimpleBrandUuid, "")
+	assert.Equal(http.StatusOK, rec.Code)
+	var brand Brand
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &brand))
+	assert.Equal(validSkeletonBrandUuid, brand.UUID)
+
+	rec = doRequest(router, "PUT", "/brands/"+validSimpleBrandUuid, brandJSON(t, validSimpleBrand))
+	assert.Equal(http.StatusConflict, rec.Code, "A merged brand can't be written again")
+	assert.Contains(rec.Body.String(), `"target":"`+validSkeletonBrandUuid+`"`)
+
+	rec = doRequest(router, "POST", "/brands/__merge", merge)
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+
+	rec = doRequest(router, "POST", "/brands/__merge", `{"source": `)
+	assert.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func TestLookupBrands(t *testing.T) {
+	router, s := newTestRouter()
+	assert.NoError(t, s.Write(validSimpleBrand))
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		uuids  []string
+	}{
+		{"found", "?authority=TME&value=123", http.StatusOK, []string{validSimpleBrandUuid}},
+		{"not found", "?authority=TME&value=456", http.StatusOK, []string{}},
+		{"many", "?authority=TME&value=456&value=123", http.StatusOK, []string{validSimpleBrandUuid}},
+		{"no value", "?authority=TME", http.StatusBadRequest, nil},
+		{"unknown authority", "?authority=ISBN&value=123", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := doRequest(router, "GET", "/brands/__lookup"+test.query, "")
+			assert.Equal(t, test.status, rec.Code)
+			if test.status != http.StatusOK {
+				return
+			}
+			var matches []IdentifierMatch
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &matches))
+			uuids := []string{}
+			for _, match := range matches {
+				uuids = append(uuids, match.Brand.UUID)
+			}
+			assert.Equal(t, test.uuids, uuids)
+		})
+	}
+}
+
+func TestGetBrands(t *testing.T) {
+	router, s := newTestRouter()
+	assert.NoError(t, s.Write(validSimpleBrand))
+
+	rec := doRequest(router, "GET", "/brands/__batch?uuid="+validSimpleBrandUuid+"&uuid="+validChildBrandUuid, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var brands map[string]*Brand
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &brands))
+	assert.Len(t, brands, 2)
+	if assert.NotNil(t, brands[validSimpleBrandUuid]) {
+		assert.Equal(t, validSimpleBrand.PrefLabel, brands[validSimpleBrandUuid].PrefLabel)
+	}
+	assert.Contains(t, rec.Body.String(), `"`+validChildBrandUuid+`":null`)
+
+	rec = doRequest(router, "GET", "/brands/__batch", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestSearchBrands(t *testing.T) {
+	router, s := newTestRouter()
+	assert.NoError(t, s.Write(validSimpleBrand))
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		uuids  []string
+	}{
+		{"exact", "?q=validsimplebrand", http.StatusOK, []string{validSimpleBrandUuid}},
+		{"fuzzy by default", "?q=validSimpelBrand", http.StatusOK, []string{validSimpleBrandUuid}},
+		{"no fuzzy", "?q=validSimpelBrand&distance=0", http.StatusOK, []string{}},
+		{"no match", "?q=nothing+like+it", http.StatusOK, []string{}},
+		{"no query", "", http.StatusBadRequest, nil},
+		{"bad distance", "?q=valid&distance=-1", http.StatusBadRequest, nil},
+		{"bad limit", "?q=valid&limit=0", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := doRequest(router, "GET", "/brands/__search"+test.query, "")
+			assert.Equal(t, test.status, rec.Code)
+			if test.status != http.StatusOK {
+				return
+			}
+			var results []SearchResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+			assert.Equal(t, test.uuids, searchUUIDs(results))
+		})
+	}
+}
+
+func TestBrandETags(t *testing.T) {
+	assert := assert.New(t)
+	router, _ := newTestRouter()
+	path := "/brands/" + validSimpleBrandUuid
+
+	rec := doRequestWithHeaders(router, "PUT", path, brandJSON(t, validSimpleBrand), map[string]string{"If-None-Match": "*"})
+	assert.Equal(http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	assert.Equal(`"`+validSimpleBrand.contentHash()+`"`, etag)
+
+	rec = doRequestWithHeaders(router, "PUT", path, brandJSON(t, validSimpleBrand), map[string]string{"If-None-Match": "*"})
+	assert.Equal(http.StatusPreconditionFailed, rec.Code, "The brand already exists")
+
+	rec = doRequest(router, "GET", path, "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(etag, rec.Header().Get("ETag"))
+
+	rec = doRequestWithHeaders(router, "GET", path, "", map[string]string{"If-None-Match": `"other", ` + etag})
+	assert.Equal(http.StatusNotModified, rec.Code)
+	assert.Empty(rec.Body.String())
+
+	rec = doRequestWithHeaders(router, "GET", path, "", map[string]string{"If-Match": `"other"`})
+	assert.Equal(http.StatusPreconditionFailed, rec.Code)
+
+	renamed := validSimpleBrand
+	renamed.PrefLabel = "renamedSimpleBrand"
+	rec = doRequestWithHeaders(router, "PUT", path, brandJSON(t, renamed), map[string]string{"If-Match": "W/" + etag})
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(`"`+renamed.contentHash()+`"`, rec.Header().Get("ETag"))
+
+	rec = doRequestWithHeaders(router, "PUT", path, brandJSON(t, validSimpleBrand), map[string]string{"If-Match": etag})
+	assert.Equal(http.StatusPreconditionFailed, rec.Code, "The brand has changed since the first version")
+	assert.JSONEq(`{"message": "Brand `+validSimpleBrandUuid+` doesn't meet the precondition because it is at version \"`+renamed.contentHash()+`\"", "version": "`+renamed.contentHash()+`"}`, rec.Body.String())
+
+	rec = doRequestWithHeaders(router, "GET", path, "", map[string]string{"If-None-Match": etag})
+	assert.Equal(http.StatusOK, rec.Code)
+}
+
+func TestGetHistory(t *testing.T) {
+	assert := assert.New(t)
+	router, _ := newTestRouter()
+
+	rec := doRequest(router, "GET", "/brands/"+validSimpleBrandUuid+"/history", "")
+	assert.Equal(http.StatusNotFound, rec.Code)
+
+	rec = doRequestWithHeaders(router, "PUT", "/brands/"+validSimpleBrandUuid, brandJSON(t, validSimpleBrand), map[string]string{"X-Request-Id": "tid_put"})
+	assert.Equal(http.StatusOK, rec.Code)
+	rec = doRequestWithHeaders(router, "DELETE", "/brands/"+validSimpleBrandUuid, "", map[string]string{"X-Request-Id": "tid_delete"})
+	assert.Equal(http.StatusOK, rec.Code)
+	rec = doRequest(router, "PUT", "/brands/"+validSimpleBrandUuid, brandJSON(t, validSimpleBrand))
+	assert.Equal(http.StatusOK, rec.Code)
+
+	rec = doRequest(router, "GET", "/brands/"+validSimpleBrandUuid+"/history", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	var changes []BrandChange
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &changes))
+	if assert.Len(changes, 3) {
+		assert.Equal("tid_put", changes[0].TransactionID)
+		assert.Equal(WriteOperation, changes[0].Operation)
+		assert.Equal("tid_delete", changes[1].TransactionID)
+		assert.Equal(ConditionalDelete, changes[1].Mode)
+		assert.True(strings.HasPrefix(changes[2].TransactionID, "tid_"), "A request without an X-Request-Id should get a generated one")
+	}
+}
+
+func TestGetBrandAsOf(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+	assert.NoError(s.Write(validSimpleBrand))
+	renamed := validSimpleBrand
+	renamed.PrefLabel = "renamedSimpleBrand"
+	assert.NoError(s.Write(renamed))
+	changes, err := s.History(validSimpleBrandUuid)
+	assert.NoError(err)
+
+	rec := doRequest(router, "GET", "/brands/"+validSimpleBrandUuid+"?asOf="+url.QueryEscape(changes[0].Timestamp), "")
+	assert.Equal(http.StatusOK, rec.Code)
+	var brand Brand
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &brand))
+	assert.Equal(validSimpleBrand.PrefLabel, brand.PrefLabel)
+
+	rec = doRequest(router, "GET", "/brands/"+validSimpleBrandUuid+"?asOf=2000-01-01T00:00:00Z", "")
+	assert.Equal(http.StatusNotFound, rec.Code)
+
+	rec = doRequest(router, "GET", "/brands/"+validSimpleBrandUuid+"?asOf=yesterday", "")
+	assert.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func TestRollbackBrand(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+	assert.NoError(s.Write(validSimpleBrand))
+	renamed := validSimpleBrand
+	renamed.PrefLabel = "renamedSimpleBrand"
+	assert.NoError(s.Write(renamed))
+
+	path := "/brands/" + validSimpleBrandUuid + "/rollback"
+	for _, query := range []string{"", "?version=1&asOf=2000-01-01T00:00:00Z", "?version=0", "?asOf=yesterday"} {
+		rec := doRequest(router, "POST", path+query, "")
+		assert.Equal(http.StatusBadRequest, rec.Code, query)
+	}
+
+	rec := doRequest(router, "POST", path+"?version=3", "")
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+
+	rec = doRequestWithHeaders(router, "POST", path+"?version=1", "", map[string]string{"X-Request-Id": "tid_rollback"})
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"uuid": "`+validSimpleBrandUuid+`", "version": 1, "status": "written"}`, rec.Body.String())
+
+	changes, err := s.History(validSimpleBrandUuid)
+	assert.NoError(err)
+	if assert.Len(changes, 3) {
+		assert.Equal("tid_rollback", changes[2].TransactionID)
+	}
+
+	rec = doRequest(router, "POST", path+"?asOf=2000-01-01T00:00:00Z", "")
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestDiffBrand(t *testing.T) {
+	assert := assert.New(t)
+	router, s := newTestRouter()
+	assert.NoError(s.Write(validSimpleBrand))
+
+	edited := validSimpleBrand
+	edited.Strapline = "A new strapline"
+	rec := doRequest(router, "POST", "/brands/"+validSimpleBrandUuid+"/diff", brandJSON(t, edited))
+	assert.Equal(http.StatusOK, rec.Code)
+	var diff BrandDiff
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.True(diff.Changed)
+	assert.Equal(map[string]FieldChange{"strapline": {From: validSimpleBrand.Strapline, To: "A new strapline"}}, diff.Fields)
+
+	brand, _, err := s.Read(validSimpleBrandUuid)
+	assert.NoError(err)
+	assert.Equal(validSimpleBrand.Strapline, brand.(Brand).Strapline, "A diff shouldn't write anything")
+
+	rec = doRequest(router, "POST", "/brands/"+validChildBrandUuid+"/diff", brandJSON(t, edited))
+	assert.Equal(http.StatusBadRequest, rec.Code)
+
+	edited.PrefLabel = ""
+	rec = doRequest(router, "POST", "/brands/"+validSimpleBrandUuid+"/diff", brandJSON(t, edited))
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	assert.Contains(rec.Body.String(), `"errors"`)
+}
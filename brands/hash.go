@@ -0,0 +1,45 @@
+package brands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+// storedSchema is part of every content hash. It goes up when Write starts storing something new for a brand,
+// so brands stored before are rewritten rather than passed over as unchanged. 2 added the lowercase
+// prefLabel and aliases that search uses.
+const storedSchema = 2
+
+// contentHash is a digest of everything Write stores for a brand, so an unchanged brand can be spotted
+// without reading it back. Types aren't written, and the order of aliases and identifiers doesn't matter.
+func (b Brand) contentHash() string {
+	// a struct of strings and string slices always marshals
+	data, _ := json.Marshal(struct {
+		Schema int `json:"schema"`
+		Brand
+	}{storedSchema, b.canonical()})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonical returns the brand without its types and with aliases and identifiers sorted, so brands with the
+// same stored content are equal
+func (b Brand) canonical() Brand {
+	canonical := b
+	canonical.Types = nil
+	canonical.Aliases = sortedCopy(b.Aliases)
+	canonical.AlternativeIdentifiers.UUIDS = sortedCopy(b.AlternativeIdentifiers.UUIDS)
+	canonical.AlternativeIdentifiers.TME = sortedCopy(b.AlternativeIdentifiers.TME)
+	return canonical
+}
+
+func sortedCopy(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,154 @@
+package brands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// CycleError is returned when giving a brand its parent would make the brand its own ancestor.
+// Path runs from the brand, through the proposed parent and its ancestors, back to the brand.
+type CycleError struct {
+	UUID string
+	Path []string
+}
+
+func (e CycleError) Error() string {
+	return fmt.Sprintf("Brand %s can't have parent %s because that would create a cycle: %s",
+		e.UUID, e.Path[1], strings.Join(e.Path, " -> "))
+}
+
+// guardAgainstCycleQuery fails the transaction it runs in if the parent already has the brand as an ancestor.
+// Cypher has no way to raise an error, so a division by zero does it; findCycle explains the failure afterwards.
+func guardAgainstCycleQuery(brand Brand) *neoism.CypherQuery {
+	return &neoism.CypherQuery{
+		Statement: `
+			OPTIONAL MATCH path = (:Thing {uuid:{paUuid}})-[:HAS_PARENT*]->(:Thing {uuid:{uuid}})
+			WITH count(path) AS cycles
+			WHERE cycles > 0
+			RETURN 1 / (cycles - cycles)`,
+		Parameters: neoism.Props{
+			"paUuid": brand.ParentUUID,
+			"uuid":   brand.UUID,
+		},
+	}
+}
+
+// findCycle returns a CycleError if the brand's parent has the brand as one of its ancestors
+func (s service) findCycle(brand Brand) error {
+	if brand.ParentUUID == "" {
+		return nil
+	}
+
+	results := []struct {
+		Path []string `json:"path"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH path = (:Thing {uuid:{paUuid}})-[:HAS_PARENT*]->(:Thing {uuid:{uuid}})
+			RETURN [n IN nodes(path) | n.uuid] AS path
+			LIMIT 1`,
+		Parameters: neoism.Props{
+			"paUuid": brand.ParentUUID,
+			"uuid":   brand.UUID,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return CycleError{UUID: brand.UUID, Path: append([]string{brand.UUID}, results[0].Path...)}
+}
+
+// BrandTree is a brand together with the brands below it in the hierarchy
+type BrandTree struct {
+	Brand
+	Children []BrandTree `json:"children,omitempty"`
+}
+
+type hierarchyRow struct {
+	Brand
+	Depth int `json:"depth"`
+}
+
+// readHierarchy runs a statement that binds n and its distance from the brand asked about as depth, returning
+// each n with the Read projection, nearest first
+func (s service) readHierarchy(statement string, uuid string) ([]hierarchyRow, error) {
+	results := []hierarchyRow{}
+	query := &neoism.CypherQuery{
+		Statement: statement + brandProjection + `, depth AS depth
+			ORDER BY depth, uuid`,
+		Parameters: neoism.Props{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+	err := s.conn.CypherBatch([]*neoism.CypherQuery{query})
+	return results, err
+}
+
+// Ancestors returns the brand's parent, its parent's parent and so on up to the root of the hierarchy. The
+// walk stops at a soft deleted brand, which is no longer part of the hierarchy. found is false if there is no
+// such brand.
+func (s service) Ancestors(uuid string) (ancestors []Brand, found bool, err error) {
+	rows, err := s.readHierarchy(`
+			MATCH path = (:Brand {uuid:{uuid}})-[:HAS_PARENT*0..]->(n:Thing)
+			WHERE NONE(a IN nodes(path) WHERE a:DeletedBrand)
+			WITH n, length(path) AS depth`, uuid)
+	if err != nil || len(rows) == 0 {
+		return nil, false, err
+	}
+
+	ancestors = []Brand{}
+	for _, row := range rows[1:] {
+		ancestors = append(ancestors, row.Brand)
+	}
+	return ancestors, true, nil
+}
+
+// Children returns the brands whose parent is the brand, in uuid order. found is false if there is no such brand.
+func (s service) Children(uuid string) (children []Brand, found bool, err error) {
+	tree, found, err := s.Descendants(uuid, 1)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	children = []Brand{}
+	for _, child := range tree.Children {
+		children = append(children, child.Brand)
+	}
+	return children, true, nil
+}
+
+// Descendants returns the brand with everything below it in the hierarchy, down to maxDepth levels below the
+// brand, or all the way down if maxDepth is negative. found is false if there is no such brand.
+func (s service) Descendants(uuid string, maxDepth int) (tree BrandTree, found bool, err error) {
+	depthRange := "0.."
+	if maxDepth >= 0 {
+		depthRange = fmt.Sprintf("0..%d", maxDepth)
+	}
+	rows, err := s.readHierarchy(fmt.Sprintf(`
+			MATCH path = (n:Brand)-[:HAS_PARENT*%s]->(:Brand {uuid:{uuid}})
+			WITH n, length(path) AS depth`, depthRange), uuid)
+	if err != nil || len(rows) == 0 {
+		return BrandTree{}, false, err
+	}
+
+	children := map[string][]Brand{}
+	for _, row := range rows[1:] {
+		children[row.ParentUUID] = append(children[row.ParentUUID], row.Brand)
+	}
+	return buildTree(rows[0].Brand, children), true, nil
+}
+
+func buildTree(brand Brand, children map[string][]Brand) BrandTree {
+	tree := BrandTree{Brand: brand}
+	for _, child := range children[brand.UUID] {
+		tree.Children = append(tree.Children, buildTree(child, children))
+	}
+	return tree
+}
@@ -0,0 +1,306 @@
+package brands
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+)
+
+const (
+	// WriteOperation is a change made by writing the brand
+	WriteOperation = "write"
+	// DeleteOperation is a change made by deleting the brand
+	DeleteOperation = "delete"
+	// RollbackOperation is a change made by writing the brand as it was after an earlier change
+	RollbackOperation = "rollback"
+	// MergeOperation is a change made by merging one brand into another, to both of them
+	MergeOperation = "merge"
+)
+
+// BrandChange is an entry in a brand's history. Version numbers a brand's changes from 1, in the order they
+// were made. Previous is nil for the write that created the brand, and Current is nil for a delete or for the
+// brand merged away by a merge. ChangedFields names the fields that differ between the two, by their JSON
+// names. A rollback gives the version it rolled back to as RollbackOf, and the brand merged away gives the
+// brand it was merged into as MergedInto.
+type BrandChange struct {
+	UUID          string     `json:"uuid"`
+	Version       int        `json:"version"`
+	Timestamp     string     `json:"timestamp"`
+	TransactionID string     `json:"transactionId"`
+	Operation     string     `json:"operation"`
+	Mode          DeleteMode `json:"mode,omitempty"`
+	RollbackOf    int        `json:"rollbackOf,omitempty"`
+	MergedInto    string     `json:"mergedInto,omitempty"`
+	ChangedFields []string   `json:"changedFields"`
+	Previous      *Brand     `json:"previous"`
+	Current       *Brand     `json:"current"`
+}
+
+// WithTransactionID returns a copy of the service that records the transaction id, usually the X-Request-Id
+// of the request, against the changes it makes
+func (s service) WithTransactionID(transactionID string) service {
+	s.transactionID = transactionID
+	return s
+}
+
+// History returns the changes made to the brand, oldest first
+func (s service) History(uuid string) ([]BrandChange, error) {
+	results := []struct {
+		BrandChange
+		Previous string `json:"previous"`
+		Current  string `json:"current"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (c:BrandChange {uuid:{uuid}})
+			RETURN c.uuid AS uuid, c.version AS version, c.timestamp AS timestamp,
+				c.transactionID AS transactionId, c.operation AS operation, c.mode AS mode, c.rollbackOf AS rollbackOf,
+				c.mergedInto AS mergedInto, c.changedFields AS changedFields, c.previous AS previous, c.current AS current
+			ORDER BY version`,
+		Parameters: neoism.Props{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+
+	changes := []BrandChange{}
+	for _, result := range results {
+		change := result.BrandChange
+		var err error
+		if change.Previous, err = decodeStoredBrand(result.Previous); err != nil {
+			return nil, err
+		}
+		if change.Current, err = decodeStoredBrand(result.Current); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// ReadAsOf returns the brand as it was at the given time, rebuilt from its history: its properties, parent,
+// identifiers and aliases as last written before then. It isn't found if it was deleted or not yet written
+// at that time, or if it hasn't changed since history began to be recorded.
+func (s service) ReadAsOf(uuid string, at time.Time) (Brand, bool, error) {
+	changes, err := s.History(uuid)
+	if err != nil {
+		return Brand{}, false, err
+	}
+	version, err := versionAt(changes, at)
+	if err != nil {
+		return Brand{}, false, err
+	}
+	change, found := changeAt(changes, version)
+	if !found || change.Current == nil {
+		return Brand{}, false, nil
+	}
+	return *change.Current, true, nil
+}
+
+// changeAt returns the change with the given version, if the history has one
+func changeAt(changes []BrandChange, version int) (BrandChange, bool) {
+	for _, change := range changes {
+		if change.Version == version {
+			return change, true
+		}
+	}
+	return BrandChange{}, false
+}
+
+// versionAt returns the version of the last of the changes made by the given time, or 0 if none were
+func versionAt(changes []BrandChange, at time.Time) (int, error) {
+	version := 0
+	for _, change := range changes {
+		changed, err := time.Parse(time.RFC3339Nano, change.Timestamp)
+		if err != nil {
+			return 0, err
+		}
+		if changed.After(at) {
+			break
+		}
+		version = change.Version
+	}
+	return version, nil
+}
+
+// RollbackError is returned when a brand can't be rolled back to the version asked for
+type RollbackError struct {
+	UUID   string
+	Reason string
+}
+
+func (e RollbackError) Error() string {
+	return fmt.Sprintf("Can't roll back brand %s: %s", e.UUID, e.Reason)
+}
+
+// RollbackResult describes a rollback. Version is the version of the brand's history that was rolled back to.
+type RollbackResult struct {
+	UUID    string      `json:"uuid"`
+	Version int         `json:"version"`
+	Status  WriteStatus `json:"status"`
+}
+
+// Rollback writes the brand as it was after the given version of its history, through WriteBrand, so the
+// rollback is checked like any other write and recorded in the history as a new version. It returns a
+// RollbackError if there is no such version or the version deleted the brand.
+func (s service) Rollback(uuid string, version int) (RollbackResult, error) {
+	changes, err := s.History(uuid)
+	if err != nil {
+		return RollbackResult{UUID: uuid, Version: version}, err
+	}
+	return s.rollback(uuid, changes, version)
+}
+
+// RollbackAsOf rolls the brand back, as Rollback does, to the version it was at at the given time
+func (s service) RollbackAsOf(uuid string, at time.Time) (RollbackResult, error) {
+	changes, err := s.History(uuid)
+	if err != nil {
+		return RollbackResult{UUID: uuid}, err
+	}
+	version, err := versionAt(changes, at)
+	if err != nil {
+		return RollbackResult{UUID: uuid}, err
+	}
+	if version == 0 {
+		return RollbackResult{UUID: uuid}, RollbackError{uuid, fmt.Sprintf("it has no history as of %s", at.Format(time.RFC3339Nano))}
+	}
+	return s.rollback(uuid, changes, version)
+}
+
+func (s service) rollback(uuid string, changes []BrandChange, version int) (RollbackResult, error) {
+	result := RollbackResult{UUID: uuid, Version: version}
+	change, found := changeAt(changes, version)
+	if !found {
+		return result, RollbackError{uuid, fmt.Sprintf("it has no version %d", version)}
+	}
+	brand := change.Current
+	if brand == nil && change.MergedInto != "" {
+		return result, RollbackError{uuid, fmt.Sprintf("version %d merged it into %s", version, change.MergedInto)}
+	}
+	if brand == nil {
+		return result, RollbackError{uuid, fmt.Sprintf("version %d deleted it", version)}
+	}
+
+	s.rollbackOf = version
+	status, err := s.WriteBrand(*brand)
+	result.Status = status
+	return result, err
+}
+
+// newChange describes a change about to be made to the brand, numbered when it is recorded
+func (s service) newChange(uuid string, operation string, mode DeleteMode, previous *Brand, current *Brand) BrandChange {
+	change := BrandChange{
+		UUID:          uuid,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339Nano),
+		TransactionID: s.transactionID,
+		Operation:     operation,
+		Mode:          mode,
+		Previous:      previous,
+		Current:       current,
+	}
+	if s.rollbackOf > 0 && operation == WriteOperation {
+		change.Operation, change.RollbackOf = RollbackOperation, s.rollbackOf
+	}
+
+	before, after := Brand{}, Brand{}
+	if previous != nil {
+		before = *previous
+	}
+	if current != nil {
+		after = *current
+	}
+	change.ChangedFields = changedFields(before, after)
+	return change
+}
+
+// changeQuery records the change with the brand's next version number. It goes in the same batch as the
+// statements making the change, so the change is recorded if and only if it is made. Each change has a key
+// made of its uuid and version, which is unique, so when two transactions number a change to the same brand
+// at once the second fails instead of recording a duplicate version.
+func changeQuery(change BrandChange) *neoism.CypherQuery {
+	var rollbackOf, mergedInto interface{}
+	if change.RollbackOf > 0 {
+		rollbackOf = change.RollbackOf
+	}
+	if change.MergedInto != "" {
+		mergedInto = change.MergedInto
+	}
+
+	return &neoism.CypherQuery{
+		Statement: `
+			OPTIONAL MATCH (c:BrandChange {uuid:{uuid}})
+			WITH coalesce(max(c.version), 0) + 1 AS version
+			CREATE (:BrandChange {key:{uuid} + '/' + version, uuid:{uuid}, version:version,
+				timestamp:{timestamp}, transactionID:{transactionID}, operation:{operation}, mode:{mode},
+				rollbackOf:{rollbackOf}, mergedInto:{mergedInto}, changedFields:{changedFields},
+				previous:{previous}, current:{current}})`,
+		Parameters: neoism.Props{
+			"uuid":          change.UUID,
+			"timestamp":     change.Timestamp,
+			"transactionID": change.TransactionID,
+			"operation":     change.Operation,
+			"mode":          string(change.Mode),
+			"rollbackOf":    rollbackOf,
+			"mergedInto":    mergedInto,
+			"changedFields": change.ChangedFields,
+			"previous":      encodeStoredBrand(change.Previous),
+			"current":       encodeStoredBrand(change.Current),
+		},
+	}
+}
+
+// changedFields returns the JSON names of the fields that differ between the brands, ignoring types and the
+// order of aliases and identifiers
+func changedFields(before Brand, after Brand) []string {
+	fields := func(b Brand) map[string]interface{} {
+		// a struct of strings and string slices always marshals
+		data, _ := json.Marshal(b.canonical())
+		values := map[string]interface{}{}
+		json.Unmarshal(data, &values)
+		return values
+	}
+	a, b := fields(before), fields(after)
+
+	changed := []string{}
+	for name, value := range a {
+		if !reflect.DeepEqual(value, b[name]) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// encodeStoredBrand turns the brand into the JSON string a change stores, as Neo4j properties can't be maps
+func encodeStoredBrand(brand *Brand) interface{} {
+	if brand == nil {
+		return nil
+	}
+	stored := *brand
+	stored.Types = nil
+	data, _ := json.Marshal(stored)
+	return string(data)
+}
+
+func decodeStoredBrand(data string) (*Brand, error) {
+	if data == "" {
+		return nil, nil
+	}
+	brand := Brand{}
+	if err := json.Unmarshal([]byte(data), &brand); err != nil {
+		return nil, err
+	}
+	return &brand, nil
+}
@@ -0,0 +1,35 @@
+package brands
+
+import (
+	"github.com/jmcvetta/neoism"
+)
+
+// IDs returns up to limit brand uuids, in order, starting after the given uuid; pass an empty
+// after for the first page, and the last uuid returned for each page after that.
+func (s service) IDs(after string, limit int) ([]string, error) {
+	results := []struct {
+		ID string `json:"id"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (n:Brand)
+			WHERE n.uuid > {after}
+			RETURN n.uuid AS id
+			ORDER BY n.uuid
+			LIMIT {limit}`,
+		Parameters: neoism.Props{
+			"after": after,
+			"limit": limit,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,138 @@
+package brands
+
+import (
+	"sort"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// IntegrityReport lists the anomalies left in the graph by earlier writes and deletes
+type IntegrityReport struct {
+	Repaired             bool                 `json:"repaired"`
+	PlaceholderParents   []PlaceholderParent  `json:"placeholderParents"`
+	DanglingIdentifiers  []DanglingIdentifier `json:"danglingIdentifiers"`
+	MissingOwnIdentifier []string             `json:"missingOwnIdentifier"`
+}
+
+// PlaceholderParent is a bare Thing that brands have as their parent but which was never written as a brand.
+// OtherRelationships counts relationships to it from anything other than its children and identifiers;
+// repair leaves placeholders that have any.
+type PlaceholderParent struct {
+	UUID               string   `json:"uuid"`
+	Children           []string `json:"children"`
+	OtherRelationships int      `json:"otherRelationships"`
+}
+
+// DanglingIdentifier is an identifier that no longer identifies anything
+type DanglingIdentifier struct {
+	Authority string `json:"authority"`
+	Value     string `json:"value"`
+}
+
+// CheckIntegrity looks for placeholder parents, identifiers that identify nothing, and brands without an
+// identifier for their own uuid. With repair it also fixes them in a single transaction: children of a
+// placeholder parent are left pending on it as under the deferred parent policy and the placeholder is
+// removed, dangling identifiers are deleted, and missing identifiers are created unless something else
+// already has them. The report describes what was found before any repair.
+func (s service) CheckIntegrity(repair bool) (IntegrityReport, error) {
+	report := IntegrityReport{
+		Repaired:             repair,
+		PlaceholderParents:   []PlaceholderParent{},
+		DanglingIdentifiers:  []DanglingIdentifier{},
+		MissingOwnIdentifier: []string{},
+	}
+
+	placeholders := []PlaceholderParent{}
+	placeholderQuery := &neoism.CypherQuery{
+		Statement: `
+			MATCH (p:Thing)<-[:HAS_PARENT]-(c:Thing)
+			WHERE labels(p) = ['Thing']
+			WITH p, collect(DISTINCT c.uuid) AS children
+			RETURN p.uuid AS uuid, children,
+				size((p)--()) - size((p)<-[:HAS_PARENT]-()) - size((p)<-[:IDENTIFIES]-(:Identifier)) AS otherRelationships
+			ORDER BY uuid`,
+		Result: &placeholders,
+	}
+
+	identifiers := []struct {
+		Value  string   `json:"value"`
+		Labels []string `json:"labels"`
+	}{}
+	danglingQuery := &neoism.CypherQuery{
+		Statement: `
+			MATCH (i:Identifier)
+			WHERE NOT (i)-[:IDENTIFIES]->()
+			RETURN i.value AS value, labels(i) AS labels
+			ORDER BY value`,
+		Result: &identifiers,
+	}
+
+	brands := []struct {
+		UUID string `json:"uuid"`
+	}{}
+	missingQuery := &neoism.CypherQuery{
+		Statement: `
+			MATCH (b:Brand)
+			WHERE NOT (:UPPIdentifier {value: b.uuid})-[:IDENTIFIES]->(b)
+			RETURN b.uuid AS uuid
+			ORDER BY uuid`,
+		Result: &brands,
+	}
+
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{placeholderQuery, danglingQuery, missingQuery}); err != nil {
+		return report, err
+	}
+
+	for _, placeholder := range placeholders {
+		sort.Strings(placeholder.Children)
+		report.PlaceholderParents = append(report.PlaceholderParents, placeholder)
+	}
+	for _, identifier := range identifiers {
+		dangling := DanglingIdentifier{Value: identifier.Value}
+		for _, label := range identifier.Labels {
+			if authority, ok := identifierAuthorities[label]; ok {
+				dangling.Authority = authority
+			}
+		}
+		report.DanglingIdentifiers = append(report.DanglingIdentifiers, dangling)
+	}
+	for _, brand := range brands {
+		report.MissingOwnIdentifier = append(report.MissingOwnIdentifier, brand.UUID)
+	}
+
+	if !repair {
+		return report, nil
+	}
+	return report, s.conn.CypherBatch(repairQueries())
+}
+
+func repairQueries() []*neoism.CypherQuery {
+	releasePlaceholders := &neoism.CypherQuery{
+		Statement: `
+			MATCH (p:Thing)<-[:HAS_PARENT]-(c:Thing)
+			WHERE labels(p) = ['Thing']
+				AND size((p)--()) = size((p)<-[:HAS_PARENT]-()) + size((p)<-[:IDENTIFIES]-(:Identifier))
+			WITH p, collect(c) AS children
+			FOREACH (c IN children | SET c.pendingParentUUID = p.uuid)
+			WITH p
+			OPTIONAL MATCH (p)<-[:IDENTIFIES]-(i:Identifier)
+			DETACH DELETE i, p`,
+	}
+
+	deleteDanglingIdentifiers := &neoism.CypherQuery{
+		Statement: `
+			MATCH (i:Identifier)
+			WHERE NOT (i)-[:IDENTIFIES]->()
+			DETACH DELETE i`,
+	}
+
+	restoreOwnIdentifiers := &neoism.CypherQuery{
+		Statement: `
+			MATCH (b:Brand)
+			WHERE NOT (:UPPIdentifier {value: b.uuid})-[:IDENTIFIES]->()
+			MERGE (i:Identifier:UPPIdentifier {value: b.uuid})
+			MERGE (i)-[:IDENTIFIES]->(b)`,
+	}
+
+	return []*neoism.CypherQuery{releasePlaceholders, deleteDanglingIdentifiers, restoreOwnIdentifiers}
+}
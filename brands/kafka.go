@@ -0,0 +1,58 @@
+package brands
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+)
+
+// KafkaSink produces each event to a Kafka topic, keyed by the brand's uuid so a brand's events stay in order
+// on one partition
+type KafkaSink struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewKafkaSink connects a sink to the brokers, producing to the topic. A publish returns once every broker in
+// sync has the events.
+func NewKafkaSink(brokers []string, topic string) (*KafkaSink, error) {
+	producer, err := sarama.NewSyncProducer(brokers, producerConfig())
+	if err != nil {
+		return nil, err
+	}
+	return newKafkaSink(producer, topic), nil
+}
+
+// producerConfig has a SyncProducer wait until every broker in sync has a message
+func producerConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	return config
+}
+
+func newKafkaSink(producer sarama.SyncProducer, topic string) *KafkaSink {
+	return &KafkaSink{producer: producer, topic: topic}
+}
+
+// Publish produces the events as JSON messages
+func (s *KafkaSink) Publish(events []ChangeEvent) error {
+	messages := make([]*sarama.ProducerMessage, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages[i] = &sarama.ProducerMessage{
+			Topic: s.topic,
+			Key:   sarama.StringEncoder(event.UUID),
+			Value: sarama.ByteEncoder(value),
+		}
+	}
+	return s.producer.SendMessages(messages)
+}
+
+// Close shuts down the producer
+func (s *KafkaSink) Close() error {
+	return s.producer.Close()
+}
@@ -0,0 +1,81 @@
+package brands
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+const testEventsTopic = "BrandChanges"
+
+// newTestBroker starts an in-process broker that leads the only partition of the events topic and answers
+// produce requests as the response says
+func newTestBroker(t *testing.T, produce *sarama.MockProduceResponse) *sarama.MockBroker {
+	// the producer's default protocol version sends version 3 produce requests
+	produce.SetVersion(3)
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testEventsTopic, 0, broker.BrokerID()),
+		"ProduceRequest": produce,
+	})
+	return broker
+}
+
+func TestKafkaSinkProducesToBroker(t *testing.T) {
+	assert := assert.New(t)
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+
+	sink, err := NewKafkaSink([]string{broker.Addr()}, testEventsTopic)
+	if !assert.NoError(err) {
+		return
+	}
+	defer sink.Close()
+
+	assert.NoError(sink.Publish([]ChangeEvent{
+		{UUID: validSimpleBrandUuid, Type: WriteOperation, Payload: &validSimpleBrand},
+		{UUID: validSimpleBrandUuid, Type: DeleteOperation},
+	}))
+}
+
+func TestKafkaSinkReturnsBrokerErrors(t *testing.T) {
+	assert := assert.New(t)
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t).SetError(testEventsTopic, 0, sarama.ErrMessageSizeTooLarge))
+	defer broker.Close()
+
+	sink, err := NewKafkaSink([]string{broker.Addr()}, testEventsTopic)
+	if !assert.NoError(err) {
+		return
+	}
+	defer sink.Close()
+
+	assert.Error(sink.Publish([]ChangeEvent{{UUID: validSimpleBrandUuid, Type: WriteOperation, Payload: &validSimpleBrand}}))
+}
+
+func TestKafkaSinkKeysMessagesByUUID(t *testing.T) {
+	assert := assert.New(t)
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewSyncProducer(t, config)
+	sink := newKafkaSink(producer, testEventsTopic)
+	defer sink.Close()
+
+	event := ChangeEvent{UUID: validSimpleBrandUuid, Type: WriteOperation, TransactionID: "tid_kafka", Payload: &validSimpleBrand}
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(message *sarama.ProducerMessage) error {
+		assert.Equal(testEventsTopic, message.Topic)
+		assert.Equal(sarama.StringEncoder(validSimpleBrandUuid), message.Key)
+		value, err := message.Value.Encode()
+		assert.NoError(err)
+		published := ChangeEvent{}
+		assert.NoError(json.Unmarshal(value, &published))
+		assert.Equal(event, published)
+		return nil
+	})
+
+	assert.NoError(sink.Publish([]ChangeEvent{event}))
+}
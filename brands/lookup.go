@@ -0,0 +1,75 @@
+package brands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// IdentifierMatch is a brand found by one of its alternative identifiers
+type IdentifierMatch struct {
+	Authority string `json:"authority"`
+	Value     string `json:"value"`
+	UUID      string `json:"uuid"`
+	Brand     Brand  `json:"brand"`
+}
+
+// UnknownAuthorityError is returned when a lookup names an authority the service doesn't write identifiers for
+type UnknownAuthorityError struct {
+	Authority string
+}
+
+func (e UnknownAuthorityError) Error() string {
+	var known []string
+	for _, authority := range identifierAuthorities {
+		known = append(known, authority)
+	}
+	sort.Strings(known)
+	return fmt.Sprintf("Unknown identifier authority %q, it must be one of %s", e.Authority, strings.Join(known, ", "))
+}
+
+// Lookup returns the brands identified by the values from the given authority ("TME" or "UPP"), ordered by
+// value. Values that don't identify a brand are left out.
+func (s service) Lookup(authority string, values []string) ([]IdentifierMatch, error) {
+	label := ""
+	for l, a := range identifierAuthorities {
+		if a == authority {
+			label = l
+		}
+	}
+	if label == "" {
+		return nil, UnknownAuthorityError{authority}
+	}
+
+	results := []struct {
+		Brand
+		Value string `json:"value"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`
+			MATCH (i:Identifier:%s)-[:IDENTIFIES]->(n:Brand)
+			WHERE i.value IN {values}
+			WITH n, i.value AS value`, label) + brandProjection + `, value AS value
+			ORDER BY value`,
+		Parameters: neoism.Props{
+			"values": nonNil(values),
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+
+	matches := []IdentifierMatch{}
+	for _, result := range results {
+		matches = append(matches, IdentifierMatch{
+			Authority: authority,
+			Value:     result.Value,
+			UUID:      result.UUID,
+			Brand:     result.Brand,
+		})
+	}
+	return matches, nil
+}
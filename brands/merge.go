@@ -0,0 +1,306 @@
+package brands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// MergeResult describes what a merge moved from the source brand onto the target. Relationships counts the
+// relationships moved by type. A relationship the target already has from the same node with the same
+// platformVersion, which is what identifies an annotation, is dropped rather than duplicated and isn't counted.
+type MergeResult struct {
+	Source        string         `json:"source"`
+	Target        string         `json:"target"`
+	Relationships map[string]int `json:"relationships"`
+	Identifiers   int            `json:"identifiers"`
+}
+
+// MergedBrandError is returned when a brand can't be written because its uuid was merged into another brand.
+// Target is the brand it now redirects to, which is the one to write.
+type MergedBrandError struct {
+	UUID   string
+	Target string
+}
+
+func (e MergedBrandError) Error() string {
+	return fmt.Sprintf("Brand %s was merged into %s, so it can't be written", e.UUID, e.Target)
+}
+
+// MergeError is returned when two brands can't be merged
+type MergeError struct {
+	Source string
+	Target string
+	Reason string
+}
+
+func (e MergeError) Error() string {
+	return fmt.Sprintf("Can't merge brand %s into %s: %s", e.Source, e.Target, e.Reason)
+}
+
+// Merge makes target the canonical brand for source. In one transaction everything related to source, such as
+// annotations and child brands, is related to target instead, source's alternative identifiers move to target,
+// and source is left as a bare Thing that REDIRECTS_TO target, which Read follows. Earlier redirects to source
+// are moved along with everything else, so they lead to target too. The moved identifiers stay with target
+// when it is written again, and source can't be written at all. The merge is recorded in the history of both
+// brands, and gives target a new version.
+func (s service) Merge(source string, target string) (MergeResult, error) {
+	result := MergeResult{Source: source, Target: target, Relationships: map[string]int{}}
+
+	if source == target {
+		return result, MergeError{source, target, "a brand can't be merged into itself"}
+	}
+	stored, err := s.storedBrands([]string{source, target})
+	if err != nil {
+		return result, err
+	}
+	for _, uuid := range []string{source, target} {
+		if _, exists := stored[uuid]; !exists {
+			return result, MergeError{source, target, fmt.Sprintf("there is no brand %s", uuid)}
+		}
+	}
+	tree, _, err := s.Descendants(source, -1)
+	if err != nil {
+		return result, err
+	}
+	if descendsFrom(tree, target) {
+		return result, MergeError{source, target, "the target descends from the source"}
+	}
+
+	types := []struct {
+		Type string `json:"type"`
+	}{}
+	incomingTypes := &neoism.CypherQuery{
+		Statement: `
+			MATCH (:Brand {uuid:{source}})<-[r]-(x:Thing)
+			WHERE x.uuid <> {target}
+			RETURN DISTINCT type(r) AS type
+			ORDER BY type`,
+		Parameters: neoism.Props{
+			"source": source,
+			"target": target,
+		},
+		Result: &types,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{incomingTypes}); err != nil {
+		return result, err
+	}
+
+	params := neoism.Props{
+		"source": source,
+		"target": target,
+	}
+	queries := []*neoism.CypherQuery{{
+		Statement: `
+			MATCH (:Brand {uuid:{source}})-[r]-(:Brand {uuid:{target}})
+			DELETE r`,
+		Parameters: params,
+	}}
+
+	moved := make([][]struct {
+		Count int `json:"moved"`
+	}, len(types))
+	for i, t := range types {
+		queries = append(queries, &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`
+				MATCH (:Brand {uuid:{source}})<-[r:%[1]s]-(x:Thing), (t:Brand {uuid:{target}})
+				OPTIONAL MATCH (x)-[e:%[1]s]->(t)
+				WHERE coalesce(e.platformVersion, '') = coalesce(r.platformVersion, '')
+				WITH r, x, t, count(e) = 0 AS missing
+				FOREACH (ignored IN CASE WHEN missing THEN [1] ELSE [] END |
+					CREATE (x)-[moved:%[1]s]->(t)
+					SET moved = r)
+				DELETE r
+				RETURN sum(CASE WHEN missing THEN 1 ELSE 0 END) AS moved`, quoteRelType(t.Type)),
+			Parameters: params,
+			Result:     &moved[i],
+		})
+	}
+
+	identifiers := []struct {
+		Count int `json:"moved"`
+	}{}
+	queries = append(queries,
+		&neoism.CypherQuery{
+			Statement: `
+				MATCH (:Brand {uuid:{source}})<-[r:IDENTIFIES]-(i:Identifier), (t:Brand {uuid:{target}})
+				MERGE (i)-[:IDENTIFIES]->(t)
+				SET i.mergedFrom = {source}
+				DELETE r
+				RETURN count(*) AS moved`,
+			Parameters: params,
+			Result:     &identifiers,
+		},
+		&neoism.CypherQuery{
+			Statement: `
+				MATCH (:Brand {uuid:{source}})-[r:HAS_PARENT]->()
+				DELETE r`,
+			Parameters: params,
+		},
+		&neoism.CypherQuery{
+			Statement: `
+				MATCH (s:Brand {uuid:{source}}), (t:Brand {uuid:{target}})
+				REMOVE s:Brand
+				REMOVE s:Concept
+				REMOVE s:Classification
+				SET s={props}
+				MERGE (s)-[:REDIRECTS_TO]->(t)`,
+			Parameters: neoism.Props{
+				"source": source,
+				"target": target,
+				"props": neoism.Props{
+					"uuid": source,
+				},
+			},
+		},
+	)
+
+	sourceBrand, targetBrand := stored[source].Brand, stored[target].Brand
+	merged := mergedBrand(sourceBrand, targetBrand)
+	queries = append(queries, &neoism.CypherQuery{
+		Statement: `
+			MATCH (t:Brand {uuid:{target}})
+			SET t.contentHash = {version}`,
+		Parameters: neoism.Props{
+			"target":  target,
+			"version": merged.contentHash(),
+		},
+	})
+
+	sourceChange := s.newChange(source, MergeOperation, "", &sourceBrand, nil)
+	sourceChange.MergedInto = target
+	targetChange := s.newChange(target, MergeOperation, "", &targetBrand, &merged)
+	queries = append(queries, changeQuery(sourceChange), changeQuery(targetChange))
+
+	if err := s.conn.CypherBatch(queries); err != nil {
+		return result, err
+	}
+	s.publish(sourceChange, targetChange)
+
+	for i, t := range types {
+		if len(moved[i]) > 0 && moved[i][0].Count > 0 {
+			result.Relationships[t.Type] = moved[i][0].Count
+		}
+	}
+	if len(identifiers) > 0 {
+		result.Identifiers = identifiers[0].Count
+	}
+	return result, nil
+}
+
+// mergedBrand returns the target brand as a merge leaves it, with the source's alternative identifiers as well
+// as its own
+func mergedBrand(source Brand, target Brand) Brand {
+	merged := target
+	merged.AlternativeIdentifiers.UUIDS = union(target.AlternativeIdentifiers.UUIDS, source.AlternativeIdentifiers.UUIDS)
+	merged.AlternativeIdentifiers.TME = union(target.AlternativeIdentifiers.TME, source.AlternativeIdentifiers.TME)
+	return merged
+}
+
+// union returns the values followed by those of more that aren't among them
+func union(values []string, more []string) []string {
+	var all []string
+	seen := map[string]bool{}
+	for _, value := range append(append([]string{}, values...), more...) {
+		if !seen[value] {
+			seen[value] = true
+			all = append(all, value)
+		}
+	}
+	return all
+}
+
+// checkMerged returns a MergedBrandError if the uuid redirects to the brand it was merged into
+func (s service) checkMerged(uuid string) error {
+	targets, err := s.mergeTargets([]string{uuid})
+	if err != nil {
+		return err
+	}
+	if target, merged := targets[uuid]; merged {
+		return MergedBrandError{UUID: uuid, Target: target}
+	}
+	return nil
+}
+
+// mergeTargets returns the uuid each of the merged uuids redirects to, keyed by the merged uuid
+func (s service) mergeTargets(uuids []string) (map[string]string, error) {
+	targets := map[string]string{}
+	if len(uuids) == 0 {
+		return targets, nil
+	}
+
+	results := []struct {
+		UUID   string `json:"uuid"`
+		Target string `json:"target"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (n:Thing)-[:REDIRECTS_TO]->(t:Thing)
+			WHERE n.uuid IN {uuids}
+			RETURN n.uuid AS uuid, t.uuid AS target`,
+		Parameters: neoism.Props{
+			"uuids": uuids,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		targets[result.UUID] = result.Target
+	}
+	return targets, nil
+}
+
+// guardMergedQuery fails the transaction if the uuid has been merged into another brand, so a write that raced
+// the merge doesn't bring the merged brand back. As with cycles, a division by zero is what fails it.
+func guardMergedQuery(uuid string) *neoism.CypherQuery {
+	return &neoism.CypherQuery{
+		Statement: `
+			OPTIONAL MATCH (:Thing {uuid:{uuid}})-[r:REDIRECTS_TO]->(:Thing)
+			WITH count(r) AS redirects
+			WHERE redirects > 0
+			RETURN 1 / (redirects - redirects)`,
+		Parameters: neoism.Props{
+			"uuid": uuid,
+		},
+	}
+}
+
+// readRedirect returns the brand the uuid was merged into, if it was, and that brand's version
+func (s service) readRedirect(uuid string) (Brand, string, bool, error) {
+	results := []struct {
+		Brand
+		Version string `json:"version"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (:Thing {uuid:{uuid}})-[:REDIRECTS_TO]->(n:Brand)` + brandProjection,
+		Parameters: neoism.Props{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return Brand{}, "", false, err
+	}
+	if len(results) == 0 {
+		return Brand{}, "", false, nil
+	}
+	return results[0].Brand, results[0].Version, true, nil
+}
+
+func descendsFrom(tree BrandTree, uuid string) bool {
+	for _, child := range tree.Children {
+		if child.UUID == uuid || descendsFrom(child, uuid) {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteRelType makes a relationship type read from the graph safe to put in a statement
+func quoteRelType(relType string) string {
+	return "`" + strings.Replace(relType, "`", "``", -1) + "`"
+}
@@ -0,0 +1,1453 @@
+package brands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// fakeNeoConnection is an in-memory neoutils.NeoConnection so the service can be tested without a Neo4j.
+// It keeps a small property graph and recognises the Cypher issued by this package (and its tests) by the
+// shape of each statement rather than interpreting Cypher in general. A statement it doesn't recognise
+// fails the batch, so changing a query without teaching the fake about it shows up as a failing test. Passing
+// against the fake says nothing about whether the Cypher itself is right; the same cases run against a real
+// Neo4j when NEO4J_TEST_URL is set, and CircleCI requires them to.
+type fakeNeoConnection struct {
+	mu          sync.Mutex
+	graph       *fakeGraph
+	indexes     map[string][]string
+	constraints map[string]string
+}
+
+func newFakeNeoConnection() *fakeNeoConnection {
+	return &fakeNeoConnection{
+		graph:       &fakeGraph{},
+		indexes:     map[string][]string{},
+		constraints: map[string]string{},
+	}
+}
+
+func (f *fakeNeoConnection) EnsureIndexes(indexes map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for label, prop := range indexes {
+		if !containsString(f.indexes[label], prop) {
+			f.indexes[label] = append(f.indexes[label], prop)
+		}
+	}
+	return nil
+}
+
+func (f *fakeNeoConnection) EnsureConstraints(constraints map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for label, prop := range constraints {
+		f.constraints[label] = prop
+	}
+	return nil
+}
+
+// CypherBatch runs the queries against a copy of the graph and only keeps the copy if every one of them
+// succeeds, which is how a transactional batch behaves against the real thing. Batches run one at a time.
+func (f *fakeNeoConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g := f.graph.clone()
+	for _, q := range queries {
+		if err := g.run(q); err != nil {
+			return err
+		}
+		if err := g.checkConstraints(f.constraints); err != nil {
+			return err
+		}
+	}
+	f.graph = g
+	return nil
+}
+
+func (f *fakeNeoConnection) String() string {
+	return "fake neo4j"
+}
+
+// fakeRow is a single result row, keyed by the column names the statement returns.
+type fakeRow map[string]interface{}
+
+type fakeStatement struct {
+	// shape holds fragments of the whitespace-normalised statement that together identify it
+	shape []string
+	run   func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error)
+}
+
+func (s fakeStatement) matches(stmt string) bool {
+	for _, fragment := range s.shape {
+		if !strings.Contains(stmt, fragment) {
+			return false
+		}
+	}
+	return true
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func normaliseStatement(stmt string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(stmt, " "))
+}
+
+func (g *fakeGraph) run(q *neoism.CypherQuery) error {
+	stmt := normaliseStatement(q.Statement)
+
+	// Parameters go over the wire as JSON, so the fake sees the same types Neo4j would
+	params := map[string]interface{}{}
+	if q.Parameters != nil {
+		if err := roundTripJSON(q.Parameters, &params); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range fakeStatements {
+		if !s.matches(stmt) {
+			continue
+		}
+		rows, err := s.run(g, stmt, params)
+		if err != nil {
+			return err
+		}
+		if q.Result == nil {
+			return nil
+		}
+		if rows == nil {
+			rows = []fakeRow{}
+		}
+		return roundTripJSON(rows, q.Result)
+	}
+	return fmt.Errorf("fake neo4j does not understand statement: %s", stmt)
+}
+
+func roundTripJSON(from interface{}, to interface{}) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
+
+var fakeStatements = []fakeStatement{
+	// service.Read
+	{
+		shape: []string{"MATCH (n:Brand {uuid:{uuid}})", "RETURN n.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			n := g.findNode("Brand", "uuid", params["uuid"])
+			if n == nil {
+				return nil, nil
+			}
+			return []fakeRow{g.brandRow(n)}, nil
+		},
+	},
+	// service.ReadMany
+	{
+		shape: []string{"UNWIND {uuids} AS requested MATCH (t:Thing {uuid:requested}) OPTIONAL MATCH (t)-[:REDIRECTS_TO]->(r:Brand)", "RETURN n.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, requested := range params["uuids"].([]interface{}) {
+				n := g.findNode("Thing", "uuid", requested)
+				if n == nil {
+					continue
+				}
+				for _, r := range g.outgoing(n, "REDIRECTS_TO") {
+					if r.to.hasLabel("Brand") {
+						n = r.to
+					}
+				}
+				if !n.hasLabel("Brand") {
+					continue
+				}
+				row := g.brandRow(n)
+				row["requested"] = requested
+				rows = append(rows, row)
+			}
+			return rows, nil
+		},
+	},
+	// service.Ancestors
+	{
+		shape: []string{"MATCH path = (:Brand {uuid:{uuid}})-[:HAS_PARENT*0..]->(n:Thing) WHERE NONE(a IN nodes(path) WHERE a:DeletedBrand) WITH n, length(path) AS depth", "RETURN n.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			n := g.findNode("Brand", "uuid", params["uuid"])
+			var rows []fakeRow
+			visited := map[*fakeNode]bool{}
+			for depth := 0; n != nil && !visited[n] && !n.hasLabel(deletedBrandLabel); depth++ {
+				visited[n] = true
+				row := g.brandRow(n)
+				row["depth"] = depth
+				rows = append(rows, row)
+				parents := g.outgoing(n, "HAS_PARENT")
+				n = nil
+				for _, r := range parents {
+					n = r.to
+				}
+			}
+			return rows, nil
+		},
+	},
+	// service.Descendants
+	{
+		shape: []string{"MATCH path = (n:Brand)-[:HAS_PARENT*0..", "]->(:Brand {uuid:{uuid}}) WITH n, length(path) AS depth", "RETURN n.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			maxDepth := -1
+			if m := depthBoundPattern.FindStringSubmatch(stmt); m[1] != "" {
+				maxDepth, _ = strconv.Atoi(m[1])
+			}
+			level := []*fakeNode{}
+			if n := g.findNode("Brand", "uuid", params["uuid"]); n != nil {
+				level = append(level, n)
+			}
+			var rows []fakeRow
+			for depth := 0; len(level) > 0 && (maxDepth < 0 || depth <= maxDepth); depth++ {
+				sort.Slice(level, func(i, j int) bool {
+					return level[i].props["uuid"].(string) < level[j].props["uuid"].(string)
+				})
+				var next []*fakeNode
+				for _, n := range level {
+					row := g.brandRow(n)
+					row["depth"] = depth
+					rows = append(rows, row)
+					for _, r := range g.incoming(n, "HAS_PARENT") {
+						if r.from.hasLabel("Brand") {
+							next = append(next, r.from)
+						}
+					}
+				}
+				level = next
+			}
+			return rows, nil
+		},
+	},
+	// service.Lookup
+	{
+		shape: []string{"-[:IDENTIFIES]->(n:Brand) WHERE i.value IN {values} WITH n, i.value AS value", "RETURN n.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			label := identifierLookupPattern.FindStringSubmatch(stmt)[1]
+			var rows []fakeRow
+			for _, i := range g.nodesLabelled(label) {
+				if !i.hasLabel("Identifier") || !containsValue(params["values"].([]interface{}), i.props["value"]) {
+					continue
+				}
+				for _, r := range g.outgoing(i, "IDENTIFIES") {
+					if r.to.hasLabel("Brand") {
+						row := g.brandRow(r.to)
+						row["value"] = i.props["value"]
+						rows = append(rows, row)
+					}
+				}
+			}
+			sort.Slice(rows, func(a, b int) bool {
+				return rows[a]["value"].(string) < rows[b]["value"].(string)
+			})
+			return rows, nil
+		},
+	},
+	// service.Search, prefLabel matches
+	{
+		shape: []string{"MATCH (n:Brand) WHERE n.prefLabelLower STARTS WITH {query}", "ORDER BY length(n.prefLabelLower), n.prefLabel, n.uuid LIMIT {limit}"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			query := params["query"].(string)
+			var matches []*fakeNode
+			for _, n := range g.nodesLabelled("Brand") {
+				if lower, ok := n.props["prefLabelLower"].(string); ok && strings.HasPrefix(lower, query) {
+					matches = append(matches, n)
+				}
+			}
+			sort.Slice(matches, func(i, j int) bool {
+				a, b := matches[i].props, matches[j].props
+				if la, lb := len([]rune(a["prefLabelLower"].(string))), len([]rune(b["prefLabelLower"].(string))); la != lb {
+					return la < lb
+				}
+				if a["prefLabel"] != b["prefLabel"] {
+					return a["prefLabel"].(string) < b["prefLabel"].(string)
+				}
+				return a["uuid"].(string) < b["uuid"].(string)
+			})
+			var rows []fakeRow
+			for _, n := range matches {
+				if len(rows) == int(params["limit"].(float64)) {
+					break
+				}
+				rows = append(rows, fakeRow{"uuid": n.props["uuid"], "prefLabel": n.props["prefLabel"], "aliases": n.props["aliases"]})
+			}
+			return rows, nil
+		},
+	},
+	// service.Search, alias and fuzzy candidates
+	{
+		shape: []string{"MATCH (n:Brand) WHERE abs(length(n.prefLabelLower) - {length}) <= {distance}", "LIMIT {candidates}"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			query := params["query"].(string)
+			length, distance := int(params["length"].(float64)), int(params["distance"].(float64))
+			closeInLength := func(lower string) bool {
+				diff := len([]rune(lower)) - length
+				return diff <= distance && -diff <= distance
+			}
+			var rows []fakeRow
+			for _, n := range g.nodesLabelled("Brand") {
+				if len(rows) == int(params["candidates"].(float64)) {
+					break
+				}
+				lower, ok := n.props["prefLabelLower"].(string)
+				match := ok && closeInLength(lower)
+				aliases, _ := n.props["aliasesLower"].([]interface{})
+				for _, alias := range aliases {
+					lower, _ := alias.(string)
+					match = match || alias != nil && (strings.HasPrefix(lower, query) || closeInLength(lower))
+				}
+				if match {
+					rows = append(rows, fakeRow{"uuid": n.props["uuid"], "prefLabel": n.props["prefLabel"], "aliases": n.props["aliases"]})
+				}
+			}
+			return rows, nil
+		},
+	},
+	// service.History
+	{
+		shape: []string{"MATCH (c:BrandChange {uuid:{uuid}}) RETURN c.uuid AS uuid, c.version AS version"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, c := range g.nodesLabelled("BrandChange") {
+				if c.props["uuid"] == params["uuid"] {
+					rows = append(rows, fakeRow{
+						"uuid":          c.props["uuid"],
+						"version":       c.props["version"],
+						"timestamp":     c.props["timestamp"],
+						"transactionId": c.props["transactionID"],
+						"operation":     c.props["operation"],
+						"mode":          c.props["mode"],
+						"rollbackOf":    c.props["rollbackOf"],
+						"mergedInto":    c.props["mergedInto"],
+						"changedFields": c.props["changedFields"],
+						"previous":      c.props["previous"],
+						"current":       c.props["current"],
+					})
+				}
+			}
+			sort.Slice(rows, func(i, j int) bool {
+				return rows[i]["version"].(float64) < rows[j]["version"].(float64)
+			})
+			return rows, nil
+		},
+	},
+	// service.Export
+	{
+		shape: []string{"MATCH (n:Brand) WHERE n.uuid > {after} WITH n ORDER BY n.uuid LIMIT {limit}", "RETURN n.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, n := range g.brandsAfter(params["after"].(string), int(params["limit"].(float64))) {
+				rows = append(rows, g.brandRow(n))
+			}
+			return rows, nil
+		},
+	},
+	// service.IDs
+	{
+		shape: []string{"MATCH (n:Brand) WHERE n.uuid > {after} RETURN n.uuid AS id ORDER BY n.uuid LIMIT {limit}"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, n := range g.brandsAfter(params["after"].(string), int(params["limit"].(float64))) {
+				rows = append(rows, fakeRow{"id": n.props["uuid"]})
+			}
+			return rows, nil
+		},
+	},
+	// service.Count
+	{
+		shape: []string{"MATCH (n:Brand) return count(n) as c"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			return []fakeRow{{"c": len(g.nodesLabelled("Brand"))}}, nil
+		},
+	},
+	// service.Write
+	{
+		shape: []string{"MATCH (n:Brand) WHERE n.uuid IN {uuids}", "RETURN n.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, uuid := range params["uuids"].([]interface{}) {
+				if n := g.findNode("Brand", "uuid", uuid); n != nil {
+					rows = append(rows, g.brandRow(n))
+				}
+			}
+			return rows, nil
+		},
+	},
+	{
+		shape: []string{"OPTIONAL MATCH (c:BrandChange {uuid:{uuid}}) WITH coalesce(max(c.version), 0) + 1 AS version CREATE (:BrandChange {"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			version := 1
+			for _, c := range g.nodesLabelled("BrandChange") {
+				if v, ok := c.props["version"].(float64); ok && c.props["uuid"] == params["uuid"] && int(v) >= version {
+					version = int(v) + 1
+				}
+			}
+			props := map[string]interface{}{
+				"key":     fmt.Sprintf("%s/%d", params["uuid"], version),
+				"version": float64(version),
+			}
+			for name, value := range params {
+				if strings.Contains(stmt, name+":{"+name+"}") {
+					props[name] = value
+				}
+			}
+			g.createNode("BrandChange").setProps(props)
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (i:Identifier) WHERE (i:TMEIdentifier AND i.value IN {tme}) OR (i:UPPIdentifier AND i.value IN {upp})", "RETURN i.value AS value, labels(i) AS labels, t.uuid AS owner"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			wanted := map[string][]interface{}{
+				tmeIdentifierLabel: params["tme"].([]interface{}),
+				uppIdentifierLabel: params["upp"].([]interface{}),
+			}
+			var rows []fakeRow
+			for _, i := range g.nodesLabelled("Identifier") {
+				for label, values := range wanted {
+					if !i.hasLabel(label) || !containsValue(values, i.props["value"]) {
+						continue
+					}
+					owners := g.outgoing(i, "IDENTIFIES")
+					if len(owners) == 0 {
+						rows = append(rows, fakeRow{"value": i.props["value"], "labels": i.labels, "owner": nil})
+					}
+					for _, r := range owners {
+						if r.to.hasLabel("Thing") && r.to.props["uuid"] != params["uuid"] {
+							rows = append(rows, fakeRow{"value": i.props["value"], "labels": i.labels, "owner": r.to.props["uuid"]})
+						}
+					}
+				}
+			}
+			sort.Slice(rows, func(a, b int) bool {
+				return rows[a]["value"].(string) < rows[b]["value"].(string)
+			})
+			return rows, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (:Thing {uuid:{uuid}})-[r:HAS_PARENT]->(:Thing) DELETE r"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if n := g.findNode("Thing", "uuid", params["uuid"]); n != nil {
+				for _, r := range g.outgoing(n, "HAS_PARENT") {
+					g.deleteRel(r)
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (t:Thing {uuid:{uuid}})<-[ir:IDENTIFIES]-(i:Identifier) WHERE i.mergedFrom IS NULL OR (i:TMEIdentifier AND i.value IN {tme}) OR (i:UPPIdentifier AND i.value IN {upp}) DELETE ir, i"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			given := map[string][]interface{}{
+				tmeIdentifierLabel: params["tme"].([]interface{}),
+				uppIdentifierLabel: params["upp"].([]interface{}),
+			}
+			if n := g.findNode("Thing", "uuid", params["uuid"]); n != nil {
+				for _, r := range g.incoming(n, "IDENTIFIES") {
+					i := r.from
+					if !i.hasLabel("Identifier") {
+						continue
+					}
+					_, merged := i.props["mergedFrom"]
+					if !merged || i.hasLabel(tmeIdentifierLabel) && containsValue(given[tmeIdentifierLabel], i.props["value"]) ||
+						i.hasLabel(uppIdentifierLabel) && containsValue(given[uppIdentifierLabel], i.props["value"]) {
+						g.deleteRel(r)
+						if err := g.deleteNode(r.from); err != nil {
+							return nil, err
+						}
+					}
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MERGE (n:Thing {uuid: {uuid}}) SET n:Brand SET n:Concept SET n:Classification SET n={props}"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			n := g.mergeNode("Thing", "uuid", params["uuid"])
+			n.addLabels("Brand", "Concept", "Classification")
+			n.setProps(params["props"].(map[string]interface{}))
+			n.removeLabels(deletedBrandLabel)
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"OPTIONAL MATCH path = (:Thing {uuid:{paUuid}})-[:HAS_PARENT*]->(:Thing {uuid:{uuid}})", "RETURN 1 / (cycles - cycles)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if g.ancestorPath(params["paUuid"], params["uuid"]) != nil {
+				return nil, errors.New("/ by zero")
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH path = (:Thing {uuid:{paUuid}})-[:HAS_PARENT*]->(:Thing {uuid:{uuid}}) RETURN [n IN nodes(path) | n.uuid] AS path LIMIT 1"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if path := g.ancestorPath(params["paUuid"], params["uuid"]); path != nil {
+				return []fakeRow{{"path": path}}, nil
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MERGE (parentupp:Identifier:UPPIdentifier{value:{paUuid}})", "MERGE (o)-[:HAS_PARENT]->(p)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			o := g.mergeNode("Thing", "uuid", params["uuid"])
+			parentUPP := g.mergeIdentifier(uppIdentifierLabel, params["paUuid"])
+			var p *fakeNode
+			for _, r := range g.outgoing(parentUPP, "IDENTIFIES") {
+				if r.to.hasLabel("Thing") {
+					p = r.to
+					break
+				}
+			}
+			if p == nil {
+				p = g.createNode("Thing")
+				p.props["uuid"] = params["paUuid"]
+				g.relate(parentUPP, "IDENTIFIES", p)
+			}
+			g.mergeRel(o, "HAS_PARENT", p)
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"OPTIONAL MATCH (n:Brand {uuid:{uuid}}) WITH [b IN collect(n) | coalesce(b.contentHash, '')] AS versions WHERE versions <> {versions}"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			versions := []interface{}{}
+			if n := g.findNode("Brand", "uuid", params["uuid"]); n != nil {
+				version, _ := n.props["contentHash"].(string)
+				versions = append(versions, version)
+			}
+			if !reflect.DeepEqual(versions, params["versions"]) {
+				return nil, errors.New("/ by zero")
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"OPTIONAL MATCH (p:Brand {uuid:{paUuid}}) WITH count(p) AS parents WHERE parents = 0 RETURN 1 / parents"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if g.findNode("Brand", "uuid", params["paUuid"]) == nil {
+				return nil, errors.New("/ by zero")
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (o:Thing {uuid:{uuid}}), (p:Brand {uuid:{paUuid}}) MERGE (o)-[:HAS_PARENT]->(p)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			o := g.findNode("Thing", "uuid", params["uuid"])
+			p := g.findNode("Brand", "uuid", params["paUuid"])
+			if o != nil && p != nil {
+				g.mergeRel(o, "HAS_PARENT", p)
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (o:Thing {uuid:{uuid}}) OPTIONAL MATCH (p:Brand {uuid:{paUuid}})", "SET o.pendingParentUUID = {paUuid}"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			o := g.findNode("Thing", "uuid", params["uuid"])
+			if o == nil {
+				return nil, nil
+			}
+			if p := g.findNode("Brand", "uuid", params["paUuid"]); p != nil {
+				g.mergeRel(o, "HAS_PARENT", p)
+			} else {
+				o.props["pendingParentUUID"] = params["paUuid"]
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (p:Brand {uuid:{uuid}}), (c:Brand {pendingParentUUID:{uuid}}) WHERE NOT (p)-[:HAS_PARENT*]->(c)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			p := g.findNode("Brand", "uuid", params["uuid"])
+			if p == nil {
+				return nil, nil
+			}
+			for _, c := range g.nodesLabelled("Brand") {
+				if c.props["pendingParentUUID"] != params["uuid"] || g.ancestorPath(params["uuid"], c.props["uuid"]) != nil {
+					continue
+				}
+				g.mergeRel(c, "HAS_PARENT", p)
+				delete(c.props, "pendingParentUUID")
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MERGE (t:Thing {uuid:{uuid}}) CREATE (i:Identifier {value:{value}}) MERGE (t)<-[:IDENTIFIES]-(i)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			t := g.mergeNode("Thing", "uuid", params["uuid"])
+			i := g.createNode("Identifier", identifierLabelIn(stmt))
+			i.props["value"] = params["value"]
+			g.mergeRel(i, "IDENTIFIES", t)
+			return nil, nil
+		},
+	},
+	// service.CheckIntegrity
+	{
+		shape: []string{"MATCH (p:Thing)<-[:HAS_PARENT]-(c:Thing) WHERE labels(p) = ['Thing']", "RETURN p.uuid AS uuid, children"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, p := range g.placeholders() {
+				children := []interface{}{}
+				for _, r := range g.incoming(p, "HAS_PARENT") {
+					if r.from.hasLabel("Thing") && !containsValue(children, r.from.props["uuid"]) {
+						children = append(children, r.from.props["uuid"])
+					}
+				}
+				rows = append(rows, fakeRow{"uuid": p.props["uuid"], "children": children, "otherRelationships": g.otherRelCount(p)})
+			}
+			return rows, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (i:Identifier) WHERE NOT (i)-[:IDENTIFIES]->() RETURN i.value AS value, labels(i) AS labels"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, i := range g.danglingIdentifiers() {
+				rows = append(rows, fakeRow{"value": i.props["value"], "labels": i.labels})
+			}
+			sort.Slice(rows, func(a, b int) bool {
+				return rows[a]["value"].(string) < rows[b]["value"].(string)
+			})
+			return rows, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (b:Brand) WHERE NOT (:UPPIdentifier {value: b.uuid})-[:IDENTIFIES]->(b) RETURN b.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, b := range g.brandsAfter("", len(g.nodes)) {
+				if !containsValue(g.identifierValues(b, uppIdentifierLabel), b.props["uuid"]) {
+					rows = append(rows, fakeRow{"uuid": b.props["uuid"]})
+				}
+			}
+			return rows, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (p:Thing)<-[:HAS_PARENT]-(c:Thing) WHERE labels(p) = ['Thing'] AND", "DETACH DELETE i, p"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			for _, p := range g.placeholders() {
+				if g.otherRelCount(p) > 0 {
+					continue
+				}
+				for _, r := range g.incoming(p, "HAS_PARENT") {
+					r.from.props["pendingParentUUID"] = p.props["uuid"]
+				}
+				for _, r := range g.incoming(p, "IDENTIFIES") {
+					if r.from.hasLabel("Identifier") {
+						g.detachDelete(r.from)
+					}
+				}
+				g.detachDelete(p)
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (i:Identifier) WHERE NOT (i)-[:IDENTIFIES]->() DETACH DELETE i"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			for _, i := range g.danglingIdentifiers() {
+				g.detachDelete(i)
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (b:Brand) WHERE NOT (:UPPIdentifier {value: b.uuid})-[:IDENTIFIES]->() MERGE (i:Identifier:UPPIdentifier {value: b.uuid})"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			for _, b := range g.nodesLabelled("Brand") {
+				if i := g.findNode(uppIdentifierLabel, "value", b.props["uuid"]); i != nil && len(g.outgoing(i, "IDENTIFIES")) > 0 {
+					continue
+				}
+				g.mergeRel(g.mergeIdentifier(uppIdentifierLabel, b.props["uuid"]), "IDENTIFIES", b)
+			}
+			return nil, nil
+		},
+	},
+	// service.Merge
+	{
+		shape: []string{"MATCH (:Thing {uuid:{uuid}})-[:REDIRECTS_TO]->(n:Brand)", "RETURN n.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if from := g.findNode("Thing", "uuid", params["uuid"]); from != nil {
+				for _, r := range g.outgoing(from, "REDIRECTS_TO") {
+					if r.to.hasLabel("Brand") {
+						return []fakeRow{g.brandRow(r.to)}, nil
+					}
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (:Brand {uuid:{source}})<-[r]-(x:Thing) WHERE x.uuid <> {target} RETURN DISTINCT type(r) AS type"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			source := g.findNode("Brand", "uuid", params["source"])
+			if source == nil {
+				return nil, nil
+			}
+			var types []string
+			for _, r := range g.relsOf(source) {
+				if r.to == source && r.from.hasLabel("Thing") && r.from.props["uuid"] != params["target"] && !containsString(types, r.kind) {
+					types = append(types, r.kind)
+				}
+			}
+			sort.Strings(types)
+			var rows []fakeRow
+			for _, t := range types {
+				rows = append(rows, fakeRow{"type": t})
+			}
+			return rows, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (:Brand {uuid:{source}})-[r]-(:Brand {uuid:{target}}) DELETE r"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			source, target := g.findNode("Brand", "uuid", params["source"]), g.findNode("Brand", "uuid", params["target"])
+			for _, r := range g.relsOf(source) {
+				if target != nil && r.other(source) == target {
+					g.deleteRel(r)
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (:Brand {uuid:{source}})<-[r:`", "-(x:Thing), (t:Brand {uuid:{target}}) OPTIONAL MATCH (x)-[e:`", "SET moved = r"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			relType := strings.Replace(quotedRelTypePattern.FindStringSubmatch(stmt)[1], "``", "`", -1)
+			source, target := g.findNode("Brand", "uuid", params["source"]), g.findNode("Brand", "uuid", params["target"])
+			count := 0
+			if source != nil && target != nil {
+				for _, r := range g.incoming(source, relType) {
+					if !r.from.hasLabel("Thing") {
+						continue
+					}
+					missing := true
+					for _, e := range g.outgoing(r.from, relType) {
+						if e.to == target && e.props["platformVersion"] == r.props["platformVersion"] {
+							missing = false
+						}
+					}
+					if missing {
+						g.relate(r.from, relType, target).setProps(r.props)
+						count++
+					}
+					g.deleteRel(r)
+				}
+			}
+			return []fakeRow{{"moved": count}}, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (:Brand {uuid:{source}})<-[r:IDENTIFIES]-(i:Identifier), (t:Brand {uuid:{target}})"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			source, target := g.findNode("Brand", "uuid", params["source"]), g.findNode("Brand", "uuid", params["target"])
+			count := 0
+			if source != nil && target != nil {
+				for _, r := range g.incoming(source, "IDENTIFIES") {
+					if r.from.hasLabel("Identifier") {
+						g.mergeRel(r.from, "IDENTIFIES", target)
+						r.from.props["mergedFrom"] = params["source"]
+						g.deleteRel(r)
+						count++
+					}
+				}
+			}
+			return []fakeRow{{"moved": count}}, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (:Brand {uuid:{source}})-[r:HAS_PARENT]->() DELETE r"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if source := g.findNode("Brand", "uuid", params["source"]); source != nil {
+				for _, r := range g.outgoing(source, "HAS_PARENT") {
+					g.deleteRel(r)
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (s:Brand {uuid:{source}}), (t:Brand {uuid:{target}}) REMOVE s:Brand REMOVE s:Concept REMOVE s:Classification", "MERGE (s)-[:REDIRECTS_TO]->(t)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			source, target := g.findNode("Brand", "uuid", params["source"]), g.findNode("Brand", "uuid", params["target"])
+			if source == nil || target == nil {
+				return nil, nil
+			}
+			source.removeLabels("Brand", "Concept", "Classification")
+			source.setProps(params["props"].(map[string]interface{}))
+			g.mergeRel(source, "REDIRECTS_TO", target)
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (t:Brand {uuid:{target}}) SET t.contentHash = {version}"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if target := g.findNode("Brand", "uuid", params["target"]); target != nil {
+				target.props["contentHash"] = params["version"]
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (n:Thing)-[:REDIRECTS_TO]->(t:Thing) WHERE n.uuid IN {uuids} RETURN n.uuid AS uuid, t.uuid AS target"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, uuid := range params["uuids"].([]interface{}) {
+				if n := g.findNode("Thing", "uuid", uuid); n != nil {
+					for _, r := range g.outgoing(n, "REDIRECTS_TO") {
+						rows = append(rows, fakeRow{"uuid": uuid, "target": r.to.props["uuid"]})
+					}
+				}
+			}
+			return rows, nil
+		},
+	},
+	{
+		shape: []string{"OPTIONAL MATCH (:Thing {uuid:{uuid}})-[r:REDIRECTS_TO]->(:Thing)", "RETURN 1 / (redirects - redirects)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if n := g.findNode("Thing", "uuid", params["uuid"]); n != nil && len(g.outgoing(n, "REDIRECTS_TO")) > 0 {
+				return nil, errors.New("/ by zero")
+			}
+			return nil, nil
+		},
+	},
+	// service.Delete
+	{
+		shape: []string{"MATCH (n:Brand {uuid: {uuid}}) REMOVE n:Brand REMOVE n:Concept REMOVE n:Classification SET n:DeletedBrand"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			n := g.findNode("Brand", "uuid", params["uuid"])
+			if n == nil {
+				return nil, nil
+			}
+			n.removeLabels("Brand", "Concept", "Classification")
+			n.addLabels(deletedBrandLabel)
+			return []fakeRow{{"uuid": n.props["uuid"]}}, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (thing:Thing {uuid: {uuid}})-[r]-(x:Thing) WHERE (thing:Brand OR thing:DeletedBrand) AND NOT (type(r) = 'HAS_PARENT' AND startNode(r) = thing)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			n := g.findNode("Thing", "uuid", params["uuid"])
+			if n == nil || !n.hasLabel("Brand") && !n.hasLabel(deletedBrandLabel) {
+				return nil, nil
+			}
+			var rows []fakeRow
+			for _, r := range g.relsOf(n) {
+				x := r.other(n)
+				if !x.hasLabel("Thing") || r.kind == "HAS_PARENT" && r.from == n {
+					continue
+				}
+				rows = append(rows, fakeRow{"type": r.kind, "outgoing": r.from == n, "uuid": x.props["uuid"], "labels": x.labels})
+			}
+			sort.Slice(rows, func(a, b int) bool {
+				if rows[a]["uuid"] != rows[b]["uuid"] {
+					return rows[a]["uuid"].(string) < rows[b]["uuid"].(string)
+				}
+				return rows[a]["type"].(string) < rows[b]["type"].(string)
+			})
+			return rows, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (thing:Thing {uuid: {uuid}}) WHERE thing:Brand OR thing:DeletedBrand OPTIONAL MATCH (thing)<-[:IDENTIFIES]-(id:Identifier)", "DETACH DELETE thing FOREACH (id IN ids | DELETE id)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			n := g.findNode("Thing", "uuid", params["uuid"])
+			if n == nil || !n.hasLabel("Brand") && !n.hasLabel(deletedBrandLabel) {
+				return nil, nil
+			}
+			var ids []*fakeNode
+			for _, r := range g.incoming(n, "IDENTIFIES") {
+				if r.from.hasLabel("Identifier") {
+					ids = append(ids, r.from)
+				}
+			}
+			labelsRemoved := n.removeLabels("Brand", "Concept", "Classification", deletedBrandLabel)
+			g.detachDelete(n)
+			for _, id := range ids {
+				if err := g.deleteNode(id); err != nil {
+					return nil, err
+				}
+			}
+			return []fakeRow{{"labelsRemoved": labelsRemoved, "identifiersRemoved": len(ids)}}, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (n:Thing {uuid: {uuid}})", "REMOVE n:Brand REMOVE n:Concept REMOVE n:Classification"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			n := g.findNode("Thing", "uuid", params["uuid"])
+			if n == nil {
+				return nil, nil
+			}
+			removed := n.removeLabels("Brand", "Concept", "Classification", deletedBrandLabel)
+			n.setProps(params["props"].(map[string]interface{}))
+			return []fakeRow{{"labelsRemoved": removed}}, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (thing:Thing {uuid: {uuid}})-[p:HAS_PARENT]->(t:Thing) DELETE p"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if n := g.findNode("Thing", "uuid", params["uuid"]); n != nil {
+				for _, r := range g.outgoing(n, "HAS_PARENT") {
+					g.deleteRel(r)
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (thing:Thing {uuid: {uuid}}) OPTIONAL MATCH (thing)-[a]-(:Thing) WITH thing, count(a) AS foreignRelationships", "DELETE thing)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			n := g.findNode("Thing", "uuid", params["uuid"])
+			if n == nil {
+				return nil, nil
+			}
+			foreign := 0
+			for _, r := range g.relsOf(n) {
+				if r.other(n).hasLabel("Thing") {
+					foreign++
+				}
+			}
+			if foreign > 0 {
+				return []fakeRow{{"foreignRelationships": foreign, "identifiersRemoved": 0}}, nil
+			}
+			identifiers := 0
+			for _, r := range g.incoming(n, "IDENTIFIES") {
+				if r.from.hasLabel("Identifier") {
+					g.deleteRel(r)
+					if err := g.deleteNode(r.from); err != nil {
+						return nil, err
+					}
+					identifiers++
+				}
+			}
+			if err := g.deleteNode(n); err != nil {
+				return nil, err
+			}
+			return []fakeRow{{"foreignRelationships": 0, "identifiersRemoved": identifiers}}, nil
+		},
+	},
+	// test helpers
+	{
+		shape: []string{"MATCH (a:Thing {uuid: {uuid}}) OPTIONAL MATCH (a)<-[:IDENTIFIES]-(i:Identifier) DETACH DELETE i, a"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if n := g.findNode("Thing", "uuid", params["uuid"]); n != nil {
+				for _, r := range g.incoming(n, "IDENTIFIES") {
+					if r.from.hasLabel("Identifier") {
+						g.detachDelete(r.from)
+					}
+				}
+				g.detachDelete(n)
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (c:BrandChange {uuid: {uuid}}) DELETE c"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			for _, c := range g.nodesLabelled("BrandChange") {
+				if c.props["uuid"] == params["uuid"] {
+					g.deleteNode(c)
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (thing) WHERE thing.uuid in {uuids} RETURN thing.uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			var rows []fakeRow
+			for _, uuid := range params["uuids"].([]interface{}) {
+				for _, n := range g.nodes {
+					if n.props["uuid"] == uuid {
+						rows = append(rows, fakeRow{"thing.uuid": uuid})
+					}
+				}
+			}
+			return rows, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (a:Thing {uuid: {uuid}}) RETURN a.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if g.findNode("Thing", "uuid", params["uuid"]) == nil {
+				return nil, nil
+			}
+			return []fakeRow{{"uuid": params["uuid"]}}, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (a:Thing {uuid: {uuid}})-[:IDENTIFIES]-(:Identifier) WITH DISTINCT a RETURN a.uuid AS uuid"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			n := g.findNode("Thing", "uuid", params["uuid"])
+			if n == nil {
+				return nil, nil
+			}
+			for _, r := range g.relsOf(n) {
+				if r.kind == "IDENTIFIES" && r.other(n).hasLabel("Identifier") {
+					return []fakeRow{{"uuid": params["uuid"]}}, nil
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"CREATE (i:Identifier:TMEIdentifier {value:{value}})"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			g.createNode("Identifier", tmeIdentifierLabel).props["value"] = params["value"]
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (:Brand {uuid:{uuid}})<-[r:IDENTIFIES]-(i:UPPIdentifier {value:{uuid}}) DELETE r, i"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			if n := g.findNode("Brand", "uuid", params["uuid"]); n != nil {
+				for _, r := range g.incoming(n, "IDENTIFIES") {
+					if r.from.hasLabel(uppIdentifierLabel) && r.from.props["value"] == params["uuid"] {
+						g.detachDelete(r.from)
+					}
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (b:Thing {uuid:{concept}}) MERGE (c:Thing {uuid:{content}}) SET c:Content MERGE (c)-[:IS_CLASSIFIED_BY {platformVersion:{platformVersion}}]->(b)"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			concept := g.findNode("Thing", "uuid", params["concept"])
+			if concept == nil {
+				return nil, nil
+			}
+			content := g.mergeNode("Thing", "uuid", params["content"])
+			content.addLabels("Content")
+			for _, r := range g.outgoing(content, "IS_CLASSIFIED_BY") {
+				if r.to == concept && r.props["platformVersion"] == params["platformVersion"] {
+					return nil, nil
+				}
+			}
+			g.relate(content, "IS_CLASSIFIED_BY", concept).setProps(map[string]interface{}{"platformVersion": params["platformVersion"]})
+			return nil, nil
+		},
+	},
+	{
+		shape: []string{"MATCH (:Thing {uuid:{content}})-[r:IS_CLASSIFIED_BY]->(:Thing {uuid:{concept}}) RETURN r.platformVersion AS platformVersion ORDER BY platformVersion"},
+		run: func(g *fakeGraph, stmt string, params map[string]interface{}) ([]fakeRow, error) {
+			content, concept := g.findNode("Thing", "uuid", params["content"]), g.findNode("Thing", "uuid", params["concept"])
+			var versions []string
+			if content != nil && concept != nil {
+				for _, r := range g.outgoing(content, "IS_CLASSIFIED_BY") {
+					if r.to == concept {
+						versions = append(versions, r.props["platformVersion"].(string))
+					}
+				}
+			}
+			sort.Strings(versions)
+			var rows []fakeRow
+			for _, version := range versions {
+				rows = append(rows, fakeRow{"platformVersion": version})
+			}
+			return rows, nil
+		},
+	},
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var quotedRelTypePattern = regexp.MustCompile("<-\\[r:`((?:[^`]|``)+)`\\]")
+
+var identifierLookupPattern = regexp.MustCompile(`MATCH \(i:Identifier:(\w+)\)`)
+
+var depthBoundPattern = regexp.MustCompile(`HAS_PARENT\*0\.\.(\d*)\]`)
+
+var identifierLabelPattern = regexp.MustCompile(`set i : (\w+)`)
+
+func identifierLabelIn(stmt string) string {
+	if m := identifierLabelPattern.FindStringSubmatch(stmt); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// brandRow builds the same projection service.Read returns for a Brand node.
+func (g *fakeGraph) brandRow(n *fakeNode) fakeRow {
+	row := fakeRow{
+		"uuid":           n.props["uuid"],
+		"prefLabel":      n.props["prefLabel"],
+		"strapline":      n.props["strapline"],
+		"descriptionXML": n.props["descriptionXML"],
+		"description":    n.props["description"],
+		"_imageUrl":      n.props["imageUrl"],
+		"aliases":        n.props["aliases"],
+		"types":          append([]string{}, n.labels...),
+		"version":        n.props["contentHash"],
+	}
+	row["parentUUID"] = n.props["pendingParentUUID"]
+	for _, r := range g.outgoing(n, "HAS_PARENT") {
+		if r.to.hasLabel("Thing") {
+			row["parentUUID"] = r.to.props["uuid"]
+		}
+	}
+	row["alternativeIdentifiers"] = map[string]interface{}{
+		"uuids": g.identifierValues(n, uppIdentifierLabel),
+		"TME":   g.identifierValues(n, tmeIdentifierLabel),
+	}
+	return row
+}
+
+func (g *fakeGraph) identifierValues(n *fakeNode, label string) []interface{} {
+	values := []interface{}{}
+	seen := map[interface{}]bool{}
+	for _, r := range g.incoming(n, "IDENTIFIES") {
+		if r.from.hasLabel(label) && !seen[r.from.props["value"]] {
+			seen[r.from.props["value"]] = true
+			values = append(values, r.from.props["value"])
+		}
+	}
+	return values
+}
+
+type fakeNode struct {
+	id     int
+	labels []string
+	props  map[string]interface{}
+}
+
+func (n *fakeNode) hasLabel(label string) bool {
+	for _, l := range n.labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *fakeNode) addLabels(labels ...string) {
+	for _, l := range labels {
+		if l != "" && !n.hasLabel(l) {
+			n.labels = append(n.labels, l)
+		}
+	}
+}
+
+// removeLabels returns how many of the labels the node actually had.
+func (n *fakeNode) removeLabels(labels ...string) int {
+	removed := 0
+	for _, label := range labels {
+		for i, l := range n.labels {
+			if l == label {
+				n.labels = append(n.labels[:i], n.labels[i+1:]...)
+				removed++
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// setProps replaces all properties, as SET n={props} does; null values are not stored.
+func (n *fakeNode) setProps(props map[string]interface{}) {
+	n.props = map[string]interface{}{}
+	for k, v := range props {
+		if v != nil {
+			n.props[k] = v
+		}
+	}
+}
+
+type fakeRel struct {
+	kind     string
+	from, to *fakeNode
+	// props is replaced rather than changed in place, so clones can share it
+	props map[string]interface{}
+}
+
+func (r *fakeRel) setProps(props map[string]interface{}) {
+	r.props = map[string]interface{}{}
+	for k, v := range props {
+		r.props[k] = v
+	}
+}
+
+func (r *fakeRel) other(n *fakeNode) *fakeNode {
+	if r.from == n {
+		return r.to
+	}
+	return r.from
+}
+
+type fakeGraph struct {
+	nodes  []*fakeNode
+	rels   []*fakeRel
+	nextID int
+}
+
+func (g *fakeGraph) clone() *fakeGraph {
+	c := &fakeGraph{nextID: g.nextID}
+	copies := map[*fakeNode]*fakeNode{}
+	for _, n := range g.nodes {
+		cn := &fakeNode{id: n.id, labels: append([]string{}, n.labels...), props: map[string]interface{}{}}
+		for k, v := range n.props {
+			cn.props[k] = v
+		}
+		copies[n] = cn
+		c.nodes = append(c.nodes, cn)
+	}
+	for _, r := range g.rels {
+		c.rels = append(c.rels, &fakeRel{kind: r.kind, from: copies[r.from], to: copies[r.to], props: r.props})
+	}
+	return c
+}
+
+func (g *fakeGraph) createNode(labels ...string) *fakeNode {
+	g.nextID++
+	n := &fakeNode{id: g.nextID, props: map[string]interface{}{}}
+	n.addLabels(labels...)
+	g.nodes = append(g.nodes, n)
+	return n
+}
+
+func (g *fakeGraph) findNode(label string, prop string, value interface{}) *fakeNode {
+	for _, n := range g.nodes {
+		if n.hasLabel(label) && n.props[prop] == value {
+			return n
+		}
+	}
+	return nil
+}
+
+func (g *fakeGraph) nodesLabelled(label string) []*fakeNode {
+	var nodes []*fakeNode
+	for _, n := range g.nodes {
+		if n.hasLabel(label) {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// ancestorPath returns the uuids on a HAS_PARENT path from one Thing up to another, or nil if there isn't one
+func (g *fakeGraph) ancestorPath(fromUUID interface{}, toUUID interface{}) []interface{} {
+	from := g.findNode("Thing", "uuid", fromUUID)
+	if from == nil {
+		return nil
+	}
+	path := []interface{}{fromUUID}
+	visited := map[*fakeNode]bool{from: true}
+	for n := from; ; {
+		parents := g.outgoing(n, "HAS_PARENT")
+		if len(parents) == 0 || visited[parents[0].to] && parents[0].to.props["uuid"] != toUUID {
+			return nil
+		}
+		n = parents[0].to
+		path = append(path, n.props["uuid"])
+		if n.props["uuid"] == toUUID {
+			return path
+		}
+		visited[n] = true
+	}
+}
+
+// placeholders returns the nodes labelled only Thing that something has as its parent, in uuid order
+func (g *fakeGraph) placeholders() []*fakeNode {
+	var placeholders []*fakeNode
+	for _, n := range g.nodes {
+		if len(n.labels) == 1 && n.hasLabel("Thing") && len(g.incoming(n, "HAS_PARENT")) > 0 {
+			placeholders = append(placeholders, n)
+		}
+	}
+	sort.Slice(placeholders, func(i, j int) bool {
+		return placeholders[i].props["uuid"].(string) < placeholders[j].props["uuid"].(string)
+	})
+	return placeholders
+}
+
+// otherRelCount counts a placeholder's relationships other than from its children and identifiers
+func (g *fakeGraph) otherRelCount(p *fakeNode) int {
+	count := len(g.relsOf(p)) - len(g.incoming(p, "HAS_PARENT"))
+	for _, r := range g.incoming(p, "IDENTIFIES") {
+		if r.from.hasLabel("Identifier") {
+			count--
+		}
+	}
+	return count
+}
+
+func (g *fakeGraph) danglingIdentifiers() []*fakeNode {
+	var dangling []*fakeNode
+	for _, i := range g.nodesLabelled("Identifier") {
+		if len(g.outgoing(i, "IDENTIFIES")) == 0 {
+			dangling = append(dangling, i)
+		}
+	}
+	return dangling
+}
+
+// brandsAfter returns up to limit Brand nodes whose uuid sorts after the given one, in uuid order
+func (g *fakeGraph) brandsAfter(after string, limit int) []*fakeNode {
+	var brands []*fakeNode
+	for _, n := range g.nodesLabelled("Brand") {
+		if uuid, _ := n.props["uuid"].(string); uuid > after {
+			brands = append(brands, n)
+		}
+	}
+	sort.Slice(brands, func(i, j int) bool {
+		return brands[i].props["uuid"].(string) < brands[j].props["uuid"].(string)
+	})
+	if len(brands) > limit {
+		brands = brands[:limit]
+	}
+	return brands
+}
+
+func (g *fakeGraph) mergeNode(label string, prop string, value interface{}) *fakeNode {
+	if n := g.findNode(label, prop, value); n != nil {
+		return n
+	}
+	n := g.createNode(label)
+	n.props[prop] = value
+	return n
+}
+
+func (g *fakeGraph) mergeIdentifier(label string, value interface{}) *fakeNode {
+	for _, n := range g.nodesLabelled(label) {
+		if n.hasLabel("Identifier") && n.props["value"] == value {
+			return n
+		}
+	}
+	n := g.createNode("Identifier", label)
+	n.props["value"] = value
+	return n
+}
+
+func (g *fakeGraph) relsOf(n *fakeNode) []*fakeRel {
+	var rels []*fakeRel
+	for _, r := range g.rels {
+		if r.from == n || r.to == n {
+			rels = append(rels, r)
+		}
+	}
+	return rels
+}
+
+func (g *fakeGraph) outgoing(n *fakeNode, kind string) []*fakeRel {
+	var rels []*fakeRel
+	for _, r := range g.rels {
+		if r.from == n && r.kind == kind {
+			rels = append(rels, r)
+		}
+	}
+	return rels
+}
+
+func (g *fakeGraph) incoming(n *fakeNode, kind string) []*fakeRel {
+	var rels []*fakeRel
+	for _, r := range g.rels {
+		if r.to == n && r.kind == kind {
+			rels = append(rels, r)
+		}
+	}
+	return rels
+}
+
+func (g *fakeGraph) relate(from *fakeNode, kind string, to *fakeNode) *fakeRel {
+	r := &fakeRel{kind: kind, from: from, to: to}
+	g.rels = append(g.rels, r)
+	return r
+}
+
+func (g *fakeGraph) mergeRel(from *fakeNode, kind string, to *fakeNode) *fakeRel {
+	for _, r := range g.outgoing(from, kind) {
+		if r.to == to {
+			return r
+		}
+	}
+	return g.relate(from, kind, to)
+}
+
+func (g *fakeGraph) deleteRel(rel *fakeRel) {
+	for i, r := range g.rels {
+		if r == rel {
+			g.rels = append(g.rels[:i], g.rels[i+1:]...)
+			return
+		}
+	}
+}
+
+// deleteNode fails like Neo4j does when the node still has relationships.
+func (g *fakeGraph) deleteNode(node *fakeNode) error {
+	if rels := g.relsOf(node); len(rels) > 0 {
+		return fmt.Errorf("Cannot delete node<%d>, because it still has relationships", node.id)
+	}
+	for i, n := range g.nodes {
+		if n == node {
+			g.nodes = append(g.nodes[:i], g.nodes[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (g *fakeGraph) detachDelete(n *fakeNode) {
+	for _, r := range g.relsOf(n) {
+		g.deleteRel(r)
+	}
+	g.deleteNode(n)
+}
+
+func (g *fakeGraph) checkConstraints(constraints map[string]string) error {
+	for label, prop := range constraints {
+		seen := map[interface{}]*fakeNode{}
+		for _, n := range g.nodesLabelled(label) {
+			value, ok := n.props[prop]
+			if !ok {
+				continue
+			}
+			if _, dup := seen[value]; dup {
+				return fmt.Errorf("Node %d already exists with label %s and property \"%s\"=[%v]", seen[value].id, label, prop, value)
+			}
+			seen[value] = n
+		}
+	}
+	return nil
+}
+
+// annotate stands in for the annotations writer, which issues Cypher the fake doesn't know about.
+func (f *fakeNeoConnection) annotate(contentUUID string, predicate string, conceptUUID string) {
+	content := f.graph.mergeNode("Thing", "uuid", contentUUID)
+	content.addLabels("Content")
+	concept := f.graph.mergeNode("Thing", "uuid", conceptUUID)
+	f.graph.mergeRel(content, predicate, concept)
+}
@@ -0,0 +1,125 @@
+package brands
+
+import (
+	"fmt"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// ParentPolicy decides what happens when a brand's parentUUID isn't a brand that has been written
+type ParentPolicy string
+
+const (
+	// PlaceholderParents links the brand to a bare Thing standing in for the parent, which becomes the
+	// parent brand if it is written later
+	PlaceholderParents ParentPolicy = "placeholder"
+	// StrictParents rejects the brand with a MissingParentError
+	StrictParents ParentPolicy = "strict"
+	// DeferredParents writes the brand without a parent and records the parent it asked for, linking the
+	// two when the parent brand is written
+	DeferredParents ParentPolicy = "deferred"
+)
+
+// ParseParentPolicy returns the named policy, or an error if there is no such policy
+func ParseParentPolicy(name string) (ParentPolicy, error) {
+	switch policy := ParentPolicy(name); policy {
+	case PlaceholderParents, StrictParents, DeferredParents:
+		return policy, nil
+	}
+	return "", fmt.Errorf("Unknown parent policy %q, it must be one of %s, %s or %s",
+		name, PlaceholderParents, StrictParents, DeferredParents)
+}
+
+// MissingParentError is returned under the strict policy when a brand's parent isn't a brand
+type MissingParentError struct {
+	UUID       string
+	ParentUUID string
+}
+
+func (e MissingParentError) Error() string {
+	return fmt.Sprintf("Brand %s can't have parent %s because there is no such brand", e.UUID, e.ParentUUID)
+}
+
+// checkParent returns a MissingParentError if the policy is strict and the brand's parent isn't a brand
+func (s service) checkParent(brand Brand) error {
+	if s.parentPolicy != StrictParents || brand.ParentUUID == "" {
+		return nil
+	}
+	parent, found, err := s.Read(brand.ParentUUID)
+	if err != nil {
+		return err
+	}
+	// Read follows merges, but a brand can't have a merged brand as its parent
+	if !found || parent.(Brand).UUID != brand.ParentUUID {
+		return MissingParentError{UUID: brand.UUID, ParentUUID: brand.ParentUUID}
+	}
+	return nil
+}
+
+// writeParentQueries returns the statements that give the brand its parent according to the policy
+func (s service) writeParentQueries(brand Brand) []*neoism.CypherQuery {
+	params := neoism.Props{
+		"paUuid": brand.ParentUUID,
+		"uuid":   brand.UUID,
+	}
+
+	switch s.parentPolicy {
+	case StrictParents:
+		// as with cycles, a division by zero fails the transaction when the parent isn't there, which
+		// checkParent then explains
+		guard := &neoism.CypherQuery{
+			Statement: `
+				OPTIONAL MATCH (p:Brand {uuid:{paUuid}})
+				WITH count(p) AS parents
+				WHERE parents = 0
+				RETURN 1 / parents`,
+			Parameters: params,
+		}
+		link := &neoism.CypherQuery{
+			Statement: `
+				MATCH (o:Thing {uuid:{uuid}}), (p:Brand {uuid:{paUuid}})
+				MERGE (o)-[:HAS_PARENT]->(p)`,
+			Parameters: params,
+		}
+		return []*neoism.CypherQuery{guard, link}
+
+	case DeferredParents:
+		link := &neoism.CypherQuery{
+			Statement: `
+				MATCH (o:Thing {uuid:{uuid}})
+				OPTIONAL MATCH (p:Brand {uuid:{paUuid}})
+				FOREACH (ignored IN CASE WHEN p IS NULL THEN [1] ELSE [] END |
+					SET o.pendingParentUUID = {paUuid})
+				FOREACH (ignored IN CASE WHEN p IS NULL THEN [] ELSE [1] END |
+					MERGE (o)-[:HAS_PARENT]->(p))`,
+			Parameters: params,
+		}
+		return []*neoism.CypherQuery{link}
+	}
+
+	link := &neoism.CypherQuery{
+		Statement: `
+                                MERGE (o:Thing {uuid: {uuid}})
+		  	   	MERGE (parentupp:Identifier:UPPIdentifier{value:{paUuid}})
+                            	MERGE (parentupp)-[:IDENTIFIES]->(p:Thing) ON CREATE SET p.uuid = {paUuid}
+		            	MERGE (o)-[:HAS_PARENT]->(p)	`,
+		Parameters: params,
+	}
+	return []*neoism.CypherQuery{link}
+}
+
+// adoptPendingChildrenQuery links the brand to every brand that was written, under the deferred policy,
+// before the brand existed. A child that is now also the brand's ancestor is left pending rather than
+// creating a cycle.
+func adoptPendingChildrenQuery(brand Brand) *neoism.CypherQuery {
+	return &neoism.CypherQuery{
+		Statement: `
+			MATCH (p:Brand {uuid:{uuid}}), (c:Brand {pendingParentUUID:{uuid}})
+			WHERE NOT (p)-[:HAS_PARENT*]->(c)
+			MERGE (c)-[:HAS_PARENT]->(p)
+			REMOVE c.pendingParentUUID`,
+		Parameters: neoism.Props{
+			"uuid": brand.UUID,
+		},
+	}
+}
@@ -0,0 +1,93 @@
+package brands
+
+import (
+	"fmt"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// AnyVersion in a Precondition stands for whatever version the stored brand has
+const AnyVersion = "*"
+
+// Precondition is what a conditional write expects of the stored brand, in the manner of the If-Match and
+// If-None-Match headers. An empty precondition allows any write.
+type Precondition struct {
+	// IfMatch lists versions, one of which the stored brand must have. AnyVersion only requires a stored brand.
+	IfMatch []string
+	// IfNoneMatch lists versions the stored brand mustn't have. AnyVersion requires there be no stored brand.
+	IfNoneMatch []string
+}
+
+// PreconditionFailedError is returned when a conditional write finds the stored brand doesn't meet its
+// precondition. Version is the stored brand's version, if there is a stored brand.
+type PreconditionFailedError struct {
+	UUID    string
+	Version string
+	Exists  bool
+}
+
+func (e PreconditionFailedError) Error() string {
+	if !e.Exists {
+		return fmt.Sprintf("Brand %s doesn't meet the precondition because it doesn't exist", e.UUID)
+	}
+	return fmt.Sprintf("Brand %s doesn't meet the precondition because it is at version %q", e.UUID, e.Version)
+}
+
+func (p Precondition) empty() bool {
+	return len(p.IfMatch) == 0 && len(p.IfNoneMatch) == 0
+}
+
+// allows says whether a stored brand with the version, or no stored brand, meets the precondition. A brand
+// without a version only matches AnyVersion.
+func (p Precondition) allows(version string, exists bool) bool {
+	if len(p.IfMatch) > 0 && !(exists && matchesVersion(p.IfMatch, version)) {
+		return false
+	}
+	if len(p.IfNoneMatch) > 0 && exists && matchesVersion(p.IfNoneMatch, version) {
+		return false
+	}
+	return true
+}
+
+func matchesVersion(versions []string, version string) bool {
+	for _, v := range versions {
+		if v == AnyVersion || v != "" && v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPrecondition returns a PreconditionFailedError if the stored brand doesn't meet the precondition
+func (s service) checkPrecondition(uuid string, precondition Precondition) error {
+	stored, err := s.storedBrands([]string{uuid})
+	if err != nil {
+		return err
+	}
+	brand, exists := stored[uuid]
+	if !precondition.allows(brand.Version, exists) {
+		return PreconditionFailedError{UUID: uuid, Version: brand.Version, Exists: exists}
+	}
+	return nil
+}
+
+// guardVersionQuery fails the transaction if the stored brand is no longer the one the precondition was
+// checked against, so a write that raced another is abandoned rather than overwriting it
+func guardVersionQuery(uuid string, version string, exists bool) *neoism.CypherQuery {
+	expected := []string{}
+	if exists {
+		expected = append(expected, version)
+	}
+	// as with cycles, a division by zero is what fails the transaction
+	return &neoism.CypherQuery{
+		Statement: `
+			OPTIONAL MATCH (n:Brand {uuid:{uuid}})
+			WITH [b IN collect(n) | coalesce(b.contentHash, '')] AS versions
+			WHERE versions <> {versions}
+			RETURN 1 / (size(versions) - size(versions))`,
+		Parameters: neoism.Props{
+			"uuid":     uuid,
+			"versions": expected,
+		},
+	}
+}
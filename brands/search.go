@@ -0,0 +1,214 @@
+package brands
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// SearchMatch says how a search result matched the query
+type SearchMatch string
+
+const (
+	// ExactMatch is a prefLabel or alias equal to the query, ignoring case
+	ExactMatch SearchMatch = "exact"
+	// PrefixMatch is a prefLabel or alias that starts with the query, ignoring case
+	PrefixMatch SearchMatch = "prefix"
+	// FuzzyMatch is a prefLabel or alias within the allowed edit distance of the query, ignoring case
+	FuzzyMatch SearchMatch = "fuzzy"
+)
+
+// SearchResult is a brand found by Search, with the prefLabel or alias that matched best
+type SearchResult struct {
+	Match    SearchMatch `json:"match"`
+	Term     string      `json:"term"`
+	Distance int         `json:"distance"`
+	Brand    Brand       `json:"brand"`
+}
+
+// fuzzyCandidateLimit caps how many brands a search reads for alias and fuzzy matching, which no index can serve
+const fuzzyCandidateLimit = 1000
+
+// searchCandidate is a brand whose prefLabel or aliases might match a search
+type searchCandidate struct {
+	UUID      string   `json:"uuid"`
+	PrefLabel string   `json:"prefLabel"`
+	Aliases   []string `json:"aliases"`
+}
+
+// Search returns up to limit brands whose prefLabel or one of whose aliases matches the query, ignoring case.
+// Exact matches rank first, then prefix matches, then fuzzy matches by the number of single character edits
+// that turn the term into the query, which can be at most maxDistance. Within those, prefLabel matches rank
+// before alias matches and shorter terms before longer ones.
+//
+// Exact and prefix matches on prefLabel are found through the index on prefLabelLower. Alias and fuzzy
+// matches are found by reading brands whose terms are close enough in length, up to fuzzyCandidateLimit of
+// them, so with more candidates than that some alias and fuzzy matches are missed.
+func (s service) Search(query string, maxDistance int, limit int) ([]SearchResult, error) {
+	query = strings.ToLower(query)
+
+	prefixed, candidates := []searchCandidate{}, []searchCandidate{}
+	// the lowercase properties are written alongside prefLabel and aliases. The shortest prefLabel matches
+	// rank best, so no more than limit of them are needed.
+	prefixQuery := &neoism.CypherQuery{
+		Statement: `
+			MATCH (n:Brand)
+			WHERE n.prefLabelLower STARTS WITH {query}
+			RETURN n.uuid AS uuid, n.prefLabel AS prefLabel, n.aliases AS aliases
+			ORDER BY length(n.prefLabelLower), n.prefLabel, n.uuid
+			LIMIT {limit}`,
+		Parameters: neoism.Props{
+			"query": query,
+			"limit": limit,
+		},
+		Result: &prefixed,
+	}
+	candidateQuery := &neoism.CypherQuery{
+		Statement: `
+			MATCH (n:Brand)
+			WHERE abs(length(n.prefLabelLower) - {length}) <= {distance}
+				OR any(alias IN n.aliasesLower WHERE alias STARTS WITH {query} OR abs(length(alias) - {length}) <= {distance})
+			RETURN n.uuid AS uuid, n.prefLabel AS prefLabel, n.aliases AS aliases
+			LIMIT {candidates}`,
+		Parameters: neoism.Props{
+			"query":      query,
+			"length":     len([]rune(query)),
+			"distance":   maxDistance,
+			"candidates": fuzzyCandidateLimit,
+		},
+		Result: &candidates,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{prefixQuery, candidateQuery}); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var ranked []rankedResult
+	for _, candidate := range append(prefixed, candidates...) {
+		if seen[candidate.UUID] {
+			continue
+		}
+		seen[candidate.UUID] = true
+
+		best, found := rankedResult{}, false
+		terms := append([]string{candidate.PrefLabel}, candidate.Aliases...)
+		for i, term := range terms {
+			result, ok := matchTerm(query, term, maxDistance)
+			if !ok {
+				continue
+			}
+			result.uuid = candidate.UUID
+			result.alias = i > 0
+			if !found || result.before(best) {
+				best, found = result, true
+			}
+		}
+		if found {
+			ranked = append(ranked, best)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].before(ranked[j])
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	uuids := make([]string, len(ranked))
+	for i, result := range ranked {
+		uuids[i] = result.uuid
+	}
+	brands, err := s.ReadMany(uuids)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []SearchResult{}
+	for _, result := range ranked {
+		// a brand deleted since the candidates were read is left out
+		if brand := brands[result.uuid]; brand != nil {
+			result.Brand = *brand
+			results = append(results, result.SearchResult)
+		}
+	}
+	return results, nil
+}
+
+type rankedResult struct {
+	SearchResult
+	uuid  string
+	alias bool
+}
+
+var matchRanks = map[SearchMatch]int{ExactMatch: 0, PrefixMatch: 1, FuzzyMatch: 2}
+
+func (r rankedResult) before(other rankedResult) bool {
+	if matchRanks[r.Match] != matchRanks[other.Match] {
+		return matchRanks[r.Match] < matchRanks[other.Match]
+	}
+	if r.Distance != other.Distance {
+		return r.Distance < other.Distance
+	}
+	if r.alias != other.alias {
+		return !r.alias
+	}
+	if len(r.Term) != len(other.Term) {
+		return len(r.Term) < len(other.Term)
+	}
+	if r.Term != other.Term {
+		return r.Term < other.Term
+	}
+	return r.uuid < other.uuid
+}
+
+// matchTerm says how the term matches the lowercase query, if it does
+func matchTerm(query string, term string, maxDistance int) (rankedResult, bool) {
+	lower := strings.ToLower(term)
+	result := rankedResult{SearchResult: SearchResult{Term: term}}
+	switch {
+	case lower == query:
+		result.Match = ExactMatch
+	case strings.HasPrefix(lower, query):
+		result.Match = PrefixMatch
+	default:
+		result.Match = FuzzyMatch
+		result.Distance = editDistance(query, lower)
+		if result.Distance > maxDistance {
+			return result, false
+		}
+	}
+	return result, true
+}
+
+// editDistance is the Levenshtein distance between a and b, counted in characters
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			substitution := previous[j-1]
+			if ra[i-1] != rb[j-1] {
+				substitution++
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,27 @@
+package brands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"ft", "ft", 0},
+		{"", "ft", 2},
+		{"alphaville", "alphavile", 1},
+		{"alphaville", "alphavillé", 1},
+		{"kitten", "sitting", 3},
+		{"simple", "simpel", 2},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, editDistance(test.a, test.b), "%q to %q", test.a, test.b)
+		assert.Equal(t, test.expected, editDistance(test.b, test.a), "%q to %q", test.b, test.a)
+	}
+}
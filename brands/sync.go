@@ -0,0 +1,75 @@
+package brands
+
+import (
+	"fmt"
+)
+
+// syncPageSize is how many stored uuids a sync reads from Neo4j at a time
+const syncPageSize = 5000
+
+// SyncReport describes the brands a full sync found stored but missing from the authoritative load
+type SyncReport struct {
+	DryRun       bool              `json:"dryRun"`
+	MaxDeletions int               `json:"maxDeletions"`
+	Stored       int               `json:"stored"`
+	Missing      []string          `json:"missing"`
+	Failed       map[string]string `json:"failed,omitempty"`
+}
+
+// TooManyDeletionsError is returned when a sync would delete more brands than allowed, which usually
+// means the load it was given was incomplete
+type TooManyDeletionsError struct {
+	Count int
+	Max   int
+}
+
+func (e TooManyDeletionsError) Error() string {
+	return fmt.Sprintf("Sync would delete %d brands, more than the maximum of %d", e.Count, e.Max)
+}
+
+// Sync deletes every stored brand whose uuid isn't in keep, using the same logic as Delete. Nothing is
+// deleted on a dry run, or if more than maxDeletions brands would go; either way the report lists the
+// brands concerned. Brands that fail to delete are listed in the report with the reason.
+func (s service) Sync(keep []string, maxDeletions int, dryRun bool) (SyncReport, error) {
+	report := SyncReport{DryRun: dryRun, MaxDeletions: maxDeletions, Missing: []string{}}
+
+	kept := make(map[string]bool, len(keep))
+	for _, uuid := range keep {
+		kept[uuid] = true
+	}
+
+	after := ""
+	for {
+		ids, err := s.IDs(after, syncPageSize)
+		if err != nil {
+			return report, err
+		}
+		for _, id := range ids {
+			if !kept[id] {
+				report.Missing = append(report.Missing, id)
+			}
+		}
+		report.Stored += len(ids)
+		if len(ids) < syncPageSize {
+			break
+		}
+		after = ids[len(ids)-1]
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	if len(report.Missing) > maxDeletions {
+		return report, TooManyDeletionsError{Count: len(report.Missing), Max: maxDeletions}
+	}
+
+	for _, uuid := range report.Missing {
+		if _, err := s.Delete(uuid); err != nil {
+			if report.Failed == nil {
+				report.Failed = map[string]string{}
+			}
+			report.Failed[uuid] = err.Error()
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,109 @@
+package brands
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// FieldError is a problem with one field of a brand, named by its JSON path
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists everything wrong with a brand
+type ValidationError struct {
+	UUID   string
+	Errors []FieldError
+}
+
+func (e ValidationError) Error() string {
+	var problems []string
+	for _, fe := range e.Errors {
+		problems = append(problems, fe.Field+" "+fe.Message)
+	}
+	return fmt.Sprintf("Invalid brand %s: %s", e.UUID, strings.Join(problems, "; "))
+}
+
+// Validate checks the brand can be written, returning a ValidationError listing every problem found
+func (b Brand) Validate() error {
+	var errs []FieldError
+	add := func(field string, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case b.UUID == "":
+		add("uuid", "is required")
+	case !uuidPattern.MatchString(b.UUID):
+		add("uuid", "%q is not a valid uuid", b.UUID)
+	}
+
+	if strings.TrimSpace(b.PrefLabel) == "" {
+		add("prefLabel", "is required")
+	}
+
+	if b.ParentUUID != "" {
+		switch {
+		case !uuidPattern.MatchString(b.ParentUUID):
+			add("parentUUID", "%q is not a valid uuid", b.ParentUUID)
+		case b.ParentUUID == b.UUID:
+			add("parentUUID", "must not be the brand's own uuid")
+		}
+	}
+
+	ownUUIDListed := false
+	for i, uuid := range b.AlternativeIdentifiers.UUIDS {
+		if !uuidPattern.MatchString(uuid) {
+			add(fmt.Sprintf("alternativeIdentifiers.uuids[%d]", i), "%q is not a valid uuid", uuid)
+		}
+		if uuid == b.UUID {
+			ownUUIDListed = true
+		}
+	}
+	if b.UUID != "" && !ownUUIDListed {
+		add("alternativeIdentifiers.uuids", "must include the brand's own uuid")
+	}
+	for _, dup := range duplicates(b.AlternativeIdentifiers.UUIDS) {
+		add("alternativeIdentifiers.uuids", "lists %q more than once", dup)
+	}
+
+	for i, tme := range b.AlternativeIdentifiers.TME {
+		if strings.TrimSpace(tme) == "" {
+			add(fmt.Sprintf("alternativeIdentifiers.TME[%d]", i), "must not be empty")
+		}
+	}
+	for _, dup := range duplicates(b.AlternativeIdentifiers.TME) {
+		add("alternativeIdentifiers.TME", "lists %q more than once", dup)
+	}
+
+	for i, alias := range b.Aliases {
+		if strings.TrimSpace(alias) == "" {
+			add(fmt.Sprintf("aliases[%d]", i), "must not be empty")
+		}
+	}
+	for _, dup := range duplicates(b.Aliases) {
+		add("aliases", "lists %q more than once", dup)
+	}
+
+	if len(errs) > 0 {
+		return ValidationError{UUID: b.UUID, Errors: errs}
+	}
+	return nil
+}
+
+// duplicates returns the values that appear more than once, in the order they first repeat
+func duplicates(values []string) []string {
+	seen := map[string]int{}
+	var dups []string
+	for _, v := range values {
+		seen[v]++
+		if seen[v] == 2 {
+			dups = append(dups, v)
+		}
+	}
+	return dups
+}
@@ -0,0 +1,64 @@
+package brands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		brand    func(b *Brand)
+		expected []FieldError
+	}{
+		{"valid", func(b *Brand) {}, nil},
+		{"missing uuid", func(b *Brand) { b.UUID = "" }, []FieldError{
+			{"uuid", "is required"},
+		}},
+		{"invalid uuids", func(b *Brand) {
+			b.UUID = "not-a-uuid"
+			b.AlternativeIdentifiers.UUIDS = []string{"not-a-uuid"}
+			b.ParentUUID = "nor-this"
+		}, []FieldError{
+			{"uuid", `"not-a-uuid" is not a valid uuid`},
+			{"parentUUID", `"nor-this" is not a valid uuid`},
+			{"alternativeIdentifiers.uuids[0]", `"not-a-uuid" is not a valid uuid`},
+		}},
+		{"empty prefLabel", func(b *Brand) { b.PrefLabel = " " }, []FieldError{
+			{"prefLabel", "is required"},
+		}},
+		{"own parent", func(b *Brand) { b.ParentUUID = b.UUID }, []FieldError{
+			{"parentUUID", "must not be the brand's own uuid"},
+		}},
+		{"own uuid not listed", func(b *Brand) { b.AlternativeIdentifiers.UUIDS = []string{validSimpleBrandUuid} }, []FieldError{
+			{"alternativeIdentifiers.uuids", "must include the brand's own uuid"},
+		}},
+		{"duplicate identifiers", func(b *Brand) {
+			b.AlternativeIdentifiers.UUIDS = []string{b.UUID, b.UUID}
+			b.AlternativeIdentifiers.TME = []string{"123123", "", "123123"}
+		}, []FieldError{
+			{"alternativeIdentifiers.uuids", `lists "a806e270-edbc-423f-b8db-d21ae90e06c8" more than once`},
+			{"alternativeIdentifiers.TME[1]", "must not be empty"},
+			{"alternativeIdentifiers.TME", `lists "123123" more than once`},
+		}},
+		{"duplicate aliases", func(b *Brand) { b.Aliases = []string{"Wonky", "Other", "Wonky", "Wonky"} }, []FieldError{
+			{"aliases", `lists "Wonky" more than once`},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			brand := validChildBrand
+			brand.AlternativeIdentifiers.UUIDS = append([]string{}, validChildBrand.AlternativeIdentifiers.UUIDS...)
+			test.brand(&brand)
+
+			err := brand.Validate()
+			if test.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, ValidationError{UUID: brand.UUID, Errors: test.expected}, err)
+		})
+	}
+}
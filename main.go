@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	_ "net/http/pprof"
+	"net/url"
 	"os"
+	"sync"
 
 	"github.com/Financial-Times/base-ft-rw-app-go/baseftrwapp"
 	"github.com/Financial-Times/brands-rw-neo4j/brands"
 	"github.com/Financial-Times/go-fthealth/v1a"
+	"github.com/Financial-Times/http-handlers-go/httphandlers"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
 	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
 	"github.com/jawher/mow.cli"
+	"github.com/rcrowley/go-metrics"
 )
 
 func main() {
@@ -46,6 +54,72 @@ func main() {
 		Desc:   "Maximum number of statements to execute per batch",
 		EnvVar: "BATCH_SIZE",
 	})
+	maxSyncDeletions := app.Int(cli.IntOpt{
+		Name:   "maxSyncDeletions",
+		Value:  50,
+		Desc:   "Maximum number of brands a full sync may delete, to stop an incomplete load wiping out brands",
+		EnvVar: "MAX_SYNC_DELETIONS",
+	})
+	parentPolicy := app.String(cli.StringOpt{
+		Name:   "parentPolicy",
+		Value:  string(brands.PlaceholderParents),
+		Desc:   "What to do when a brand's parent isn't a brand: placeholder (link to a bare Thing), strict (reject the brand) or deferred (link when the parent is written)",
+		EnvVar: "PARENT_POLICY",
+	})
+	eventSink := app.String(cli.StringOpt{
+		Name:   "eventSink",
+		Value:  "",
+		Desc:   "Where to publish brand change events: stdout, file (append to eventsFile) or kafka (produce to eventsTopic on kafkaBrokers). Leave empty to publish none",
+		EnvVar: "EVENT_SINK",
+	})
+	eventsFile := app.String(cli.StringOpt{
+		Name:   "eventsFile",
+		Value:  "brand-events.log",
+		Desc:   "File the file event sink appends to",
+		EnvVar: "EVENTS_FILE",
+	})
+	kafkaBrokers := app.Strings(cli.StringsOpt{
+		Name:   "kafkaBrokers",
+		Value:  []string{"localhost:9092"},
+		Desc:   "Kafka broker addresses, comma separated, for the kafka event sink and the consumer",
+		EnvVar: "KAFKA_BROKERS",
+	})
+	eventsTopic := app.String(cli.StringOpt{
+		Name:   "eventsTopic",
+		Value:  "BrandChanges",
+		Desc:   "Kafka topic the kafka event sink produces to",
+		EnvVar: "EVENTS_TOPIC",
+	})
+	consume := app.Bool(cli.BoolOpt{
+		Name:   "consume",
+		Value:  false,
+		Desc:   "Whether to also write the brands on brandsTopic, parking those that can't be written on deadLetterTopic",
+		EnvVar: "CONSUME",
+	})
+	brandsTopic := app.String(cli.StringOpt{
+		Name:   "brandsTopic",
+		Value:  "Brands",
+		Desc:   "Kafka topic of brand JSON messages to consume",
+		EnvVar: "BRANDS_TOPIC",
+	})
+	deadLetterTopic := app.String(cli.StringOpt{
+		Name:   "deadLetterTopic",
+		Value:  "BrandsDeadLetter",
+		Desc:   "Kafka topic consumed messages that can't be written are parked on",
+		EnvVar: "DEAD_LETTER_TOPIC",
+	})
+	consumerGroup := app.String(cli.StringOpt{
+		Name:   "consumerGroup",
+		Value:  "brands-rw-neo4j",
+		Desc:   "Kafka consumer group to consume brandsTopic as",
+		EnvVar: "CONSUMER_GROUP",
+	})
+	consumerMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "consumerMaxAttempts",
+		Value:  10,
+		Desc:   "How many times the consumer tries to write a brand while Neo4j fails before parking its message on deadLetterTopic",
+		EnvVar: "CONSUMER_MAX_ATTEMPTS",
+	})
 	logMetrics := app.Bool(cli.BoolOpt{
 		Name:   "logMetrics",
 		Value:  false,
@@ -59,26 +133,106 @@ func main() {
 		Desc:  "environment this app is running in",
 	})
 
-	app.Action = func() {
+	// connect returns the Neo4j connection and parent policy the brands service is configured with
+	connect := func() (neoutils.NeoConnection, brands.ParentPolicy) {
+		policy, err := brands.ParseParentPolicy(*parentPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+
 		conf := neoutils.DefaultConnectionConfig()
-		conf.BatchSize = *batchSize
+		// neoutils' batching runs concurrent callers' queries in one transaction, so a write refused by one of
+		// the guards (a cycle, a failed If-Match, a merged uuid) would fail everyone else's writes with it.
+		// The service batches its own writes up to batchSize instead.
+		conf.BatchSize = 0
 		db, err := neoutils.Connect(*neoURL, conf)
 		if err != nil {
 			log.Errorf("Could not connect to neo4j, error=[%s]\n", err)
 		}
 
-		brandsDriver := brands.NewCypherBrandsService(db)
+		return db, policy
+	}
+
+	// events returns the sink brand changes are published to, or nil if they aren't published
+	events := func() brands.EventSink {
+		switch *eventSink {
+		case "":
+			return nil
+		case "stdout":
+			return brands.NewWriterSink(os.Stdout)
+		case "file":
+			f, err := os.OpenFile(*eventsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				log.Fatalf("Could not open events file, error=[%s]", err)
+			}
+			return brands.NewWriterSink(f)
+		case "kafka":
+			sink, err := brands.NewKafkaSink(*kafkaBrokers, *eventsTopic)
+			if err != nil {
+				log.Fatalf("Could not connect to kafka, error=[%s]", err)
+			}
+			return sink
+		}
+		log.Fatalf("Unknown event sink %q, it must be stdout, file or kafka", *eventSink)
+		return nil
+	}
+
+	app.Command("integrity", "Report placeholder parents, dangling identifiers and brands missing their own identifier, then exit", func(cmd *cli.Cmd) {
+		repair := cmd.Bool(cli.BoolOpt{
+			Name:  "repair",
+			Value: false,
+			Desc:  "Fix the anomalies found as well as reporting them",
+		})
+		cmd.Action = func() {
+			db, policy := connect()
+			brandsDriver := brands.NewCypherBrandsService(db, *batchSize, policy)
+			brandsDriver.Initialise()
+
+			report, err := brandsDriver.CheckIntegrity(*repair)
+			if err != nil {
+				log.Fatalf("Integrity check failed, error=[%s]", err)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		}
+	})
+
+	app.Action = func() {
+		db, policy := connect()
+		brandsDriver := brands.NewCypherBrandsService(db, *batchSize, policy).WithEventSink(events())
 		brandsDriver.Initialise()
 
 		baseftrwapp.OutputMetricsIfRequired(*graphiteTCPAddress, *graphitePrefix, *logMetrics)
 
-		services := map[string]baseftrwapp.Service{
-			"brands": brandsDriver,
+		checks := []v1a.Check{makeCheck(brandsDriver, db)}
+
+		if *consume {
+			status := &consumerStatus{}
+			go func() {
+				err := brands.ConsumeBrands(context.Background(), brandsDriver, brands.ConsumerConfig{
+					Brokers:         *kafkaBrokers,
+					Group:           *consumerGroup,
+					Topic:           *brandsTopic,
+					DeadLetterTopic: *deadLetterTopic,
+					MaxAttempts:     *consumerMaxAttempts,
+				})
+				if err != nil {
+					log.Errorf("Brands consumer stopped, error=[%s]", err)
+					status.stopped(err)
+				}
+			}()
+			checks = append(checks, makeConsumerCheck(status, *brandsTopic))
 		}
 
-		var checks []v1a.Check
-		for _, service := range services {
-			checks = append(checks, makeCheck(service, db))
+		// baseftrwapp's router can't have routes added to it, so the brand-specific endpoints are served by
+		// our own router in front of it
+		router := mux.NewRouter()
+		brands.NewBrandsHandler(brandsDriver, *maxSyncDeletions).RegisterHandlers(router)
+		http.Handle("/brands/", brandRoutes(router))
+
+		services := map[string]baseftrwapp.Service{
+			"brands": brandsDriver,
 		}
 
 		baseftrwapp.RunServerWithConf(baseftrwapp.RWConf{
@@ -95,6 +249,25 @@ func main() {
 	app.Run(os.Args)
 }
 
+// brandRoutes serves the /brands requests the router has a route for, logging them and recording metrics as
+// baseftrwapp does for its own, and hands the rest to baseftrwapp
+func brandRoutes(router *mux.Router) http.Handler {
+	var routed http.Handler = router
+	routed = httphandlers.TransactionAwareRequestLoggingHandler(log.StandardLogger(), routed)
+	routed = httphandlers.HTTPMetricsHandler(metrics.DefaultRegistry, routed)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var match mux.RouteMatch
+		if router.Match(r, &match) {
+			routed.ServeHTTP(w, r)
+			return
+		}
+		// baseftrwapp registers its router at / when the server starts, so it is looked up per request
+		root, _ := http.DefaultServeMux.Handler(&http.Request{Method: r.Method, URL: &url.URL{Path: "/"}})
+		root.ServeHTTP(w, r)
+	})
+}
+
 func makeCheck(service baseftrwapp.Service, cr neoutils.CypherRunner) v1a.Check {
 	return v1a.Check{
 		BusinessImpact:   "Cannot read/write brands via this writer",
@@ -105,3 +278,32 @@ func makeCheck(service baseftrwapp.Service, cr neoutils.CypherRunner) v1a.Check
 		Checker:          func() (string, error) { return "", service.Check() },
 	}
 }
+
+// consumerStatus holds the error that stopped the brands consumer, if it has stopped
+type consumerStatus struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (s *consumerStatus) stopped(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *consumerStatus) check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func makeConsumerCheck(status *consumerStatus, topic string) v1a.Check {
+	return v1a.Check{
+		BusinessImpact:   "Brands published to Kafka are not being written to Neo4j",
+		Name:             "Check the brands consumer is running",
+		PanicGuide:       "https://sites.google.com/a/ft.com/ft-technology-service-transition/home/run-book-library/brand-rw-neo4j",
+		Severity:         2,
+		TechnicalSummary: fmt.Sprintf("The consumer of %s has stopped, so the service needs restarting once Kafka is reachable", topic),
+		Checker:          func() (string, error) { return "", status.check() },
+	}
+}